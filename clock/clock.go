@@ -1,9 +1,17 @@
 package clock
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -11,28 +19,476 @@ import (
 // Concurrent clock server
 // =========
 
-func RunClock() error {
-	l, err := net.Listen("tcp", "localhost:9000")
+// Server tells clients the current time.
+//
+// Clients select how they are told the time by sending commands,
+// one per line:
+//
+//	tz NAME          IANA time zone name, e.g. Europe/Warsaw, UTC or Local
+//	format LAYOUT    rfc3339, unix (seconds since epoch), kitchen
+//	                 or a custom Go time layout, e.g. 2006-01-02 15:04
+//	interval D       tick interval, e.g. 1s or 500ms
+//	sync T1          answered with timestamps to synchronize
+//	                 clocks with, see Syncer
+//
+// Invalid commands are answered with a line starting with "error:".
+type Server struct {
+	// Network is "tcp" (default), "tcp4", "tcp6" or "unix" for stream
+	// transports and "udp", "udp4", "udp6" or "unixgram" for datagrams.
+	Network string
+
+	// Addr is an address to listen on, "localhost:9000" if empty.
+	// For unix networks it is a path of the socket file.
+	Addr string
+
+	// Interval between time updates sent to stream clients, 5s if zero.
+	Interval time.Duration
+
+	// Location clients are told the time in until they select
+	// a time zone, time.Local if nil.
+	Location *time.Location
+
+	// Layout of the time sent to clients until they select
+	// a format, "15:04:05" if empty.
+	Layout string
+
+	// MaxConns limits the number of connected stream clients.
+	// Clients over the limit are told so and disconnected.
+	// A client which has gone holds its slot until the next
+	// time update to it fails. No limit if zero.
+	MaxConns int
+
+	// IdleTimeout disconnects stream clients which send nothing
+	// for that long. Clients stay connected by sending commands
+	// or empty lines. No timeout if zero.
+	IdleTimeout time.Duration
+
+	Logger *log.Logger
+
+	mu          sync.Mutex
+	closed      bool
+	quit        chan struct{}
+	listeners   map[net.Listener]struct{}
+	packetConns map[net.PacketConn]struct{}
+	conns       map[net.Conn]struct{}
+	handlers    sync.WaitGroup
+}
+
+// ErrServerClosed is returned by Serve, ServePacket and
+// ListenAndServe after a call to Shutdown.
+var ErrServerClosed = errors.New("clock: server closed")
+
+// Accept errors are retried with backoff growing
+// from minAcceptDelay up to maxAcceptDelay.
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// MinInterval is the shortest tick interval clients can select.
+const MinInterval = 10 * time.Millisecond
+
+var errUnknownCommand = errors.New("unknown command")
+
+// settings control how a client is told the time.
+type settings struct {
+	loc      *time.Location
+	layout   string // time layout or "unix" for seconds since epoch
+	interval time.Duration
+}
+
+func (s *Server) settings() settings {
+	st := settings{loc: s.Location, layout: s.Layout, interval: s.Interval}
+	if st.loc == nil {
+		st.loc = time.Local
+	}
+	if st.layout == "" {
+		st.layout = "15:04:05"
+	}
+	if st.interval == 0 {
+		st.interval = 5 * time.Second
+	}
+	return st
+}
+
+// apply changes the settings as requested by the command line.
+func (st *settings) apply(line string) error {
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "tz":
+		loc, err := time.LoadLocation(arg)
+		if err != nil {
+			return fmt.Errorf("unknown time zone %q", arg)
+		}
+		st.loc = loc
+	case "format":
+		switch strings.ToLower(arg) {
+		case "":
+			return fmt.Errorf("missing format")
+		case "rfc3339":
+			st.layout = time.RFC3339
+		case "kitchen":
+			st.layout = time.Kitchen
+		case "unix":
+			st.layout = "unix"
+		default:
+			st.layout = arg
+		}
+	case "interval":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return fmt.Errorf("invalid interval %q", arg)
+		}
+		if d < MinInterval {
+			return fmt.Errorf("interval %v shorter than %v", d, MinInterval)
+		}
+		st.interval = d
+	default:
+		return fmt.Errorf("%w %q", errUnknownCommand, cmd)
+	}
+	return nil
+}
+
+// format returns the time as a line to send to the client.
+func (st settings) format(t time.Time) string {
+	if st.layout == "unix" {
+		return strconv.FormatInt(t.Unix(), 10) + "\n"
+	}
+	return t.In(st.loc).Format(st.layout) + "\n"
+}
+
+// ListenAndServe listens on s.Network address s.Addr and then calls
+// Serve or ServePacket, depending on the network, to handle clients.
+func (s *Server) ListenAndServe() error {
+	network, addr := s.Network, s.Addr
+	if network == "" {
+		network = "tcp"
+	}
+	if addr == "" {
+		addr = "localhost:9000"
+	}
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		return s.ServePacket(pc)
+	}
+	l, err := net.Listen(network, addr)
 	if err != nil {
 		return err
 	}
+	return s.Serve(l)
+}
+
+// Serve accepts stream connections on the listener l. Each
+// client gets the current time every tick interval until it
+// disconnects. Serve closes l when it returns.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+
+	var delay time.Duration
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			log.Println(err)
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if delay == 0 {
+				delay = minAcceptDelay
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			s.logf("accept error: %v; retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-s.quitChan():
+			}
+			continue
+		}
+		delay = 0
+		if err := s.trackConn(conn); err != nil {
+			io.WriteString(conn, "error: "+err.Error()+"\n")
+			conn.Close()
 			continue
 		}
-		go handleConnectionClock(conn)
+		go func() {
+			defer s.untrackConn(conn)
+			s.handleConnectionClock(conn)
+		}()
 	}
 }
 
-func handleConnectionClock(c net.Conn) {
+// Shutdown stops accepting clients and tells connected stream
+// clients' handlers to disconnect, then waits for them to finish.
+// If ctx expires first, Shutdown closes the remaining connections
+// and returns the context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.init()
+	if !s.closed {
+		s.closed = true
+		close(s.quit)
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	for pc := range s.packetConns {
+		pc.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// init must be called with s.mu held.
+func (s *Server) init() {
+	if s.quit == nil {
+		s.quit = make(chan struct{})
+		s.listeners = make(map[net.Listener]struct{})
+		s.packetConns = make(map[net.PacketConn]struct{})
+		s.conns = make(map[net.Conn]struct{})
+	}
+}
+
+func (s *Server) quitChan() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	return s.quit
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// trackListener adds or removes the listener closed on shutdown.
+// It reports false if the server is already shut down.
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// trackPacketConn is trackListener for datagram connections.
+func (s *Server) trackPacketConn(pc net.PacketConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if !add {
+		delete(s.packetConns, pc)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.packetConns[pc] = struct{}{}
+	return true
+}
+
+// trackConn registers the client's connection unless
+// the server is shutting down or full.
+func (s *Server) trackConn(c net.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.closed {
+		return ErrServerClosed
+	}
+	if s.MaxConns > 0 && len(s.conns) >= s.MaxConns {
+		return errors.New("too many connections")
+	}
+	s.conns[c] = struct{}{}
+	s.handlers.Add(1)
+	return nil
+}
+
+func (s *Server) untrackConn(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	s.handlers.Done()
+}
+
+func (s *Server) logf(format string, v ...any) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// ServePacket answers every datagram read from pc with a datagram
+// holding the current time. Lines of the request datagram are
+// commands applied to that answer only.
+func (s *Server) ServePacket(pc net.PacketConn) error {
+	defer pc.Close()
+	if !s.trackPacketConn(pc, true) {
+		return ErrServerClosed
+	}
+	defer s.trackPacketConn(pc, false)
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.logf("%v", err)
+				continue
+			}
+			return err
+		}
+		reply := s.answer(buf[:n], time.Now())
+		if _, err := pc.WriteTo([]byte(reply), addr); err != nil {
+			s.logf("%v", err)
+		}
+	}
+}
+
+// answer returns the reply to the datagram request
+// received at the time.
+func (s *Server) answer(req []byte, received time.Time) string {
+	st := s.settings()
+	input := bufio.NewScanner(bytes.NewReader(req))
+	for input.Scan() {
+		if strings.TrimSpace(input.Text()) == "" {
+			continue
+		}
+		if arg, ok := strings.CutPrefix(input.Text(), "sync "); ok {
+			reply, err := syncAnswer(arg, received)
+			if err != nil {
+				return "error: " + err.Error() + "\n"
+			}
+			return reply
+		}
+		if err := st.apply(input.Text()); err != nil {
+			// datagram clients often send a dummy payload just
+			// to ask for the time, so only known commands count
+			if errors.Is(err, errUnknownCommand) {
+				continue
+			}
+			return "error: " + err.Error() + "\n"
+		}
+	}
+	return st.format(time.Now())
+}
+
+// command is a line read from a stream client.
+type command struct {
+	line     string
+	received time.Time
+}
+
+func (s *Server) handleConnectionClock(c net.Conn) {
 	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	commands := make(chan command)
+	go func() {
+		input := bufio.NewScanner(c)
+		for {
+			if s.IdleTimeout > 0 {
+				c.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+			}
+			if !input.Scan() {
+				if input.Err() == nil {
+					// the client is done sending commands but may
+					// still read the ticks, until a write fails
+					return
+				}
+				close(commands) // the client has gone or idled too long
+				return
+			}
+			if strings.TrimSpace(input.Text()) == "" {
+				continue // keeps the client connected
+			}
+			select {
+			case commands <- command{line: input.Text(), received: time.Now()}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	quit := s.quitChan()
+	st := s.settings()
+	ticker := time.NewTicker(st.interval)
+	defer ticker.Stop()
+	line := st.format(time.Now())
 	for {
-		_, err := io.WriteString(c, time.Now().Format("15:04:05\n"))
+		_, err := io.WriteString(c, line)
 		if err != nil {
 			return // it will disconnect a client
 		}
-		time.Sleep(5 * time.Second)
+		select {
+		case <-quit:
+			return // the server is shutting down
+		case now := <-ticker.C:
+			line = st.format(now)
+		case cmd, ok := <-commands:
+			if !ok {
+				return // the client has gone
+			}
+			if arg, ok := strings.CutPrefix(cmd.line, "sync "); ok {
+				// answered at once, between the ticks
+				reply, err := syncAnswer(arg, cmd.received)
+				if err != nil {
+					reply = "error: " + err.Error() + "\n"
+				}
+				line = reply
+				continue
+			}
+			if err := st.apply(cmd.line); err != nil {
+				line = "error: " + err.Error() + "\n"
+				continue
+			}
+			ticker.Reset(st.interval)
+			line = st.format(time.Now())
+		}
 	}
 }
+
+// RunClock starts the clock server on TCP localhost:9000.
+func RunClock() error {
+	var s Server
+	return s.ListenAndServe()
+}
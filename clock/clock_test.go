@@ -1,8 +1,523 @@
 package clock_test
 
-import "testing"
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
 
-func TestClock(t *testing.T) {
+	"github.com/qba73/gocp/clock"
+)
+
+func TestServer_ShutdownDisconnectsClientsAndStopsServing(t *testing.T) {
+	t.Parallel()
+
+	s := &clock.Server{Interval: time.Hour}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() { served <- s.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	input := bufio.NewScanner(conn)
+	if !input.Scan() {
+		t.Fatal(input.Err())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, clock.ErrServerClosed) {
+		t.Errorf("want ErrServerClosed from Serve, got %v", err)
+	}
+	if input.Scan() {
+		t.Errorf("want client disconnected, got %q", input.Text())
+	}
+	if input.Err() != nil {
+		t.Errorf("want connection closed cleanly, got %v", input.Err())
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("want server not accepting connections")
+	}
+	if err := s.Serve(l); !errors.Is(err, clock.ErrServerClosed) {
+		t.Errorf("want ErrServerClosed serving after shutdown, got %v", err)
+	}
+}
+
+func TestServer_ShutdownStopsServingPackets(t *testing.T) {
+	t.Parallel()
+
+	s := &clock.Server{}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() { served <- s.ServePacket(pc) }()
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, clock.ErrServerClosed) {
+			t.Errorf("want ErrServerClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServePacket still running after shutdown")
+	}
+}
+
+func TestServer_RejectsClientsOverMaxConns(t *testing.T) {
+	t.Parallel()
+
+	s := &clock.Server{Interval: 20 * time.Millisecond, MaxConns: 1}
+	first := listenAndDial(t, s, "tcp")
+	first.SetDeadline(time.Now().Add(2 * time.Second))
+	input := bufio.NewScanner(first)
+	if !input.Scan() {
+		t.Fatal(input.Err())
+	}
+
+	second, err := net.Dial("tcp", first.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(2 * time.Second))
+	rejected := bufio.NewScanner(second)
+	if !rejected.Scan() {
+		t.Fatal(rejected.Err())
+	}
+	if want, got := "error: too many connections", rejected.Text(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if rejected.Scan() {
+		t.Errorf("want rejected client disconnected, got %q", rejected.Text())
+	}
+
+	// a slot frees when the first client leaves
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		third, err := net.Dial("tcp", first.RemoteAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		third.SetDeadline(time.Now().Add(2 * time.Second))
+		input := bufio.NewScanner(third)
+		input.Scan()
+		third.Close()
+		if !strings.HasPrefix(input.Text(), "error: ") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("want client accepted after a slot freed")
+}
+
+func TestServer_DisconnectsIdleClients(t *testing.T) {
+	t.Parallel()
+
+	conn := listenAndDial(t, &clock.Server{Interval: 10 * time.Millisecond, IdleTimeout: 100 * time.Millisecond}, "tcp")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// empty lines keep the client connected past the timeout
+	start := time.Now()
+	for time.Since(start) < 200*time.Millisecond {
+		if _, err := conn.Write([]byte("\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	input := bufio.NewScanner(conn)
+	for input.Scan() {
+	}
+	if idle := time.Since(start); idle < 200*time.Millisecond || idle > time.Second {
+		t.Errorf("want client disconnected after idling 100ms, took %v", idle)
+	}
+}
+
+func TestServer_KeepsTickingToHalfClosedClients(t *testing.T) {
+	t.Parallel()
+
+	conn := listenAndDial(t, &clock.Server{Interval: 10 * time.Millisecond}, "tcp")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	input := bufio.NewScanner(conn)
+	for i := 0; i < 5; i++ {
+		if !input.Scan() {
+			t.Fatalf("want ticks after closing the sending side, got %d: %v", i, input.Err())
+		}
+	}
+}
+
+func TestServer_RetriesAcceptErrorsWithBackoff(t *testing.T) {
 	t.Parallel()
 
+	l := &failingListener{failures: 4}
+	start := time.Now()
+	err := (&clock.Server{Logger: log.New(io.Discard, "", 0)}).Serve(l)
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("want net.ErrClosed, got %v", err)
+	}
+	// 5ms + 10ms + 20ms + 40ms
+	if elapsed := time.Since(start); elapsed < 75*time.Millisecond {
+		t.Errorf("want accept retried with growing delays, took %v", elapsed)
+	}
+	if l.accepts != 5 {
+		t.Errorf("want 5 accepts, got %d", l.accepts)
+	}
+}
+
+// failingListener fails to accept a number of times
+// and then reports being closed.
+type failingListener struct {
+	failures int
+	accepts  int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts++
+	if l.accepts <= l.failures {
+		return nil, errors.New("too many open files")
+	}
+	return nil, net.ErrClosed
+}
+
+func (l *failingListener) Close() error   { return nil }
+func (l *failingListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestServer_TellsTimeOverAllTransports(t *testing.T) {
+	t.Parallel()
+
+	for _, network := range []string{"tcp", "unix", "udp", "unixgram"} {
+		network := network
+		t.Run(network, func(t *testing.T) {
+			t.Parallel()
+
+			conn := listenAndDial(t, &clock.Server{Interval: 10 * time.Millisecond}, network)
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 64)
+			for i := 0; i < 2; i++ {
+				// datagram clients ask for the time, stream clients get it pushed
+				if network == "udp" || network == "unixgram" {
+					if _, err := conn.Write([]byte("time?")); err != nil {
+						t.Fatal(err)
+					}
+				}
+				n, err := conn.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := time.Parse("15:04:05\n", string(buf[:n])); err != nil {
+					t.Errorf("want time, got %q: %v", buf[:n], err)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_FormatsTimeAsClientSelects(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name     string
+		commands string
+		valid    func(string) error
+	}{
+		{
+			name:     "rfc3339 in UTC",
+			commands: "tz UTC\nformat rfc3339\n",
+			valid: func(s string) error {
+				_, err := time.Parse("2006-01-02T15:04:05Z", s)
+				return err
+			},
+		},
+		{
+			name:     "unix epoch",
+			commands: "format unix\n",
+			valid: func(s string) error {
+				_, err := strconv.ParseInt(s, 10, 64)
+				return err
+			},
+		},
+		{
+			name:     "custom layout in Tokyo",
+			commands: "tz Asia/Tokyo\nformat 2006-01-02 15:04 MST\n",
+			valid: func(s string) error {
+				_, err := time.Parse("2006-01-02 15:04 JST", s)
+				return err
+			},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := listenAndDial(t, &clock.Server{Interval: time.Hour}, "tcp")
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Write([]byte(tc.commands)); err != nil {
+				t.Fatal(err)
+			}
+			// the server answers every command with the time,
+			// the last answer follows all the commands
+			input := bufio.NewScanner(conn)
+			var line string
+			for i := 0; i <= strings.Count(tc.commands, "\n"); i++ {
+				if !input.Scan() {
+					t.Fatal(input.Err())
+				}
+				line = input.Text()
+			}
+			if err := tc.valid(line); err != nil {
+				t.Errorf("got %q: %v", line, err)
+			}
+		})
+	}
+}
+
+func TestServer_TicksAtClientSelectedInterval(t *testing.T) {
+	t.Parallel()
+
+	conn := listenAndDial(t, &clock.Server{Interval: time.Hour}, "tcp")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("interval 20ms\n")); err != nil {
+		t.Fatal(err)
+	}
+	input := bufio.NewScanner(conn)
+	for i := 0; i < 5; i++ {
+		if !input.Scan() {
+			t.Fatalf("want a tick every 20ms, got %v", input.Err())
+		}
+	}
+}
+
+func TestServer_RejectsInvalidCommands(t *testing.T) {
+	t.Parallel()
+
+	conn := listenAndDial(t, &clock.Server{Interval: time.Hour}, "tcp")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	input := bufio.NewScanner(conn)
+	input.Scan() // the time told on connect
+	for _, cmd := range []string{"tz Mars/Olympus", "interval 1ns", "interval soon", "alarm 7:00"} {
+		if _, err := conn.Write([]byte(cmd + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if !input.Scan() {
+			t.Fatal(input.Err())
+		}
+		if !strings.HasPrefix(input.Text(), "error: ") {
+			t.Errorf("%s: want error, got %q", cmd, input.Text())
+		}
+	}
+}
+
+func TestServer_AppliesDatagramCommandsToAnswer(t *testing.T) {
+	t.Parallel()
+
+	conn := listenAndDial(t, &clock.Server{}, "udp")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	ask := func(req string) string {
+		t.Helper()
+		if _, err := conn.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(buf[:n]))
+	}
+
+	got := ask("format unix\n")
+	if _, err := strconv.ParseInt(got, 10, 64); err != nil {
+		t.Errorf("want unix time, got %q", got)
+	}
+	got = ask("tz Nowhere\n")
+	if !strings.HasPrefix(got, "error: ") {
+		t.Errorf("want error, got %q", got)
+	}
+}
+
+func TestWall_ShowsClocksSideBySide(t *testing.T) {
+	t.Parallel()
+
+	addr := func(s *clock.Server) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go s.Serve(l)
+		return l.Addr().String()
+	}
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Close()
+
+	out := &syncBuffer{}
+	w := clock.Wall{
+		Clocks: []clock.Clock{
+			{Name: "London", Addr: addr(&clock.Server{}), Zone: "UTC"},
+			{Name: "Tokyo", Addr: addr(&clock.Server{}), Zone: "Asia/Tokyo"},
+			{Name: "Atlantis", Addr: down.Addr().String()},
+		},
+		Output:   out,
+		Interval: 20 * time.Millisecond,
+		Layout:   "15:04 MST",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	want := regexp.MustCompile(`(?m)^\d\d:\d\d UTC  \d\d:\d\d JST  down$`)
+	deadline := time.Now().Add(2 * time.Second)
+	for !want.MatchString(out.String()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.HasPrefix(got, "London     Tokyo      Atlantis\n") {
+		t.Errorf("want header, got:\n%s", got)
+	}
+	if !want.MatchString(got) {
+		t.Errorf("want row of UTC time, JST time and down clock, got:\n%s", got)
+	}
+}
+
+func TestWall_SizesColumnsToFormattedTimes(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go (&clock.Server{}).Serve(l)
+
+	out := &syncBuffer{}
+	w := clock.Wall{
+		Clocks: []clock.Clock{
+			{Name: "A", Addr: l.Addr().String()},
+			{Name: "B", Addr: l.Addr().String()},
+		},
+		Output:   out,
+		Interval: 20 * time.Millisecond,
+		Layout:   "unix",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	want := regexp.MustCompile(`(?m)^\d{10}  \d{10}$`)
+	deadline := time.Now().Add(2 * time.Second)
+	for !want.MatchString(out.String()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.HasPrefix(got, "A           B\n") {
+		t.Errorf("want header as wide as unix times, got:\n%s", got)
+	}
+	if !want.MatchString(got) {
+		t.Errorf("want row of two unix times, got:\n%s", got)
+	}
+}
+
+// syncBuffer is a buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// listenAndDial starts the server on the network and returns
+// a client connection to it.
+func listenAndDial(t *testing.T, s *clock.Server, network string) net.Conn {
+	t.Helper()
+	dir := t.TempDir()
+	var conn net.Conn
+	switch network {
+	case "tcp", "unix":
+		addr := "127.0.0.1:0"
+		if network == "unix" {
+			addr = filepath.Join(dir, "clock.sock")
+		}
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go s.Serve(l)
+		conn, err = net.Dial(network, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "udp", "unixgram":
+		addr := "127.0.0.1:0"
+		if network == "unixgram" {
+			addr = filepath.Join(dir, "clock.sock")
+		}
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pc.Close() })
+		go s.ServePacket(pc)
+		if network == "unixgram" {
+			// datagram unix clients need their own address to get replies
+			laddr := &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: network}
+			conn, err = net.DialUnix(network, laddr, pc.LocalAddr().(*net.UnixAddr))
+		} else {
+			conn, err = net.Dial(network, pc.LocalAddr().String())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
 }
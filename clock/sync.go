@@ -0,0 +1,173 @@
+package clock
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========
+// Time synchronization
+// =========
+
+// The sync command asks the server for its timestamps, the way
+// NTP does. The client sends the time it sent the request at,
+// in nanoseconds since the Unix epoch:
+//
+//	sync T1
+//
+// and the server answers with the client's timestamp followed by
+// the times it received the request and transmitted the answer at:
+//
+//	sync T1 T2 T3
+
+// Sample is a single exchange of timestamps with a clock server.
+type Sample struct {
+	Originate   time.Time // T1, the client sent the request
+	Receive     time.Time // T2, the server received the request
+	Transmit    time.Time // T3, the server sent the answer
+	Destination time.Time // T4, the client received the answer
+}
+
+// Offset returns how much the server's clock is ahead of the
+// client's, assuming the network delay is the same both ways.
+func (s Sample) Offset() time.Duration {
+	return (s.Receive.Sub(s.Originate) + s.Transmit.Sub(s.Destination)) / 2
+}
+
+// Delay returns the round trip time spent on the network,
+// not counting the time the server took to answer.
+func (s Sample) Delay() time.Duration {
+	return s.Destination.Sub(s.Originate) - s.Transmit.Sub(s.Receive)
+}
+
+// syncAnswer returns the answer to the sync command argument
+// of the request received at the time.
+func syncAnswer(arg string, received time.Time) (string, error) {
+	t1, err := strconv.ParseInt(strings.TrimSpace(arg), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp %q", arg)
+	}
+	return fmt.Sprintf("sync %d %d %d\n", t1, received.UnixNano(), time.Now().UnixNano()), nil
+}
+
+// Estimate is the offset and delay averaged over samples.
+type Estimate struct {
+	// Offset to add to the local time to get the server's time.
+	Offset time.Duration
+
+	// Delay is the mean round trip time.
+	Delay time.Duration
+
+	Samples []Sample
+}
+
+// Syncer estimates the offset of the local clock
+// from a clock server's clock.
+type Syncer struct {
+	// Network is "tcp" (default), "tcp4", "tcp6", "unix",
+	// "udp", "udp4" or "udp6".
+	Network string
+
+	// Addr of the clock server, "localhost:9000" if empty.
+	Addr string
+
+	// Samples is a number of exchanges to average, 8 if zero.
+	Samples int
+
+	// Timeout waiting for a single answer, 1s if zero.
+	// Exchanges which time out are not counted.
+	Timeout time.Duration
+}
+
+// ErrNoSamples means none of the server's answers arrived in time.
+var ErrNoSamples = errors.New("no answers from the clock server")
+
+// Sync exchanges timestamps with the server and returns
+// the offset and delay averaged over all the samples.
+func (s *Syncer) Sync(ctx context.Context) (Estimate, error) {
+	network, addr := s.Network, s.Addr
+	if network == "" {
+		network = "tcp"
+	}
+	if addr == "" {
+		addr = "localhost:9000"
+	}
+	samples, timeout := s.Samples, s.Timeout
+	if samples <= 0 {
+		samples = 8
+	}
+	if timeout == 0 {
+		timeout = time.Second
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return Estimate{}, err
+	}
+	defer conn.Close()
+
+	var est Estimate
+	input := bufio.NewReader(conn)
+	for i := 0; i < samples; i++ {
+		if err := ctx.Err(); err != nil {
+			return Estimate{}, err
+		}
+		deadline := time.Now().Add(timeout)
+		if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+			deadline = dl
+		}
+		conn.SetDeadline(deadline)
+		sample, err := exchange(conn, input)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue // the request or the answer got lost
+			}
+			return Estimate{}, err
+		}
+		est.Samples = append(est.Samples, sample)
+		est.Offset += sample.Offset()
+		est.Delay += sample.Delay()
+	}
+	if len(est.Samples) == 0 {
+		return Estimate{}, ErrNoSamples
+	}
+	est.Offset /= time.Duration(len(est.Samples))
+	est.Delay /= time.Duration(len(est.Samples))
+	return est, nil
+}
+
+// exchange sends a sync request and reads the answer to it,
+// skipping the time ticks and late answers to earlier requests.
+func exchange(conn net.Conn, input *bufio.Reader) (Sample, error) {
+	t1 := time.Now()
+	if _, err := fmt.Fprintf(conn, "sync %d\n", t1.UnixNano()); err != nil {
+		return Sample{}, err
+	}
+	for {
+		line, err := input.ReadString('\n')
+		if err != nil {
+			return Sample{}, err
+		}
+		t4 := time.Now()
+		if msg, ok := strings.CutPrefix(line, "error: "); ok {
+			return Sample{}, errors.New(strings.TrimSpace(msg))
+		}
+		var orig, t2, t3 int64
+		if _, err := fmt.Sscanf(line, "sync %d %d %d\n", &orig, &t2, &t3); err != nil || orig != t1.UnixNano() {
+			continue
+		}
+		return Sample{
+			Originate:   t1,
+			Receive:     time.Unix(0, t2),
+			Transmit:    time.Unix(0, t3),
+			Destination: t4,
+		}, nil
+	}
+}
@@ -0,0 +1,92 @@
+package clock_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/clock"
+)
+
+func TestSample_ComputesOffsetAndDelay(t *testing.T) {
+	t.Parallel()
+
+	// the server is 1s ahead, the request takes 10ms, the answer 30ms
+	// and the server spends 5ms answering
+	t1 := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := clock.Sample{
+		Originate:   t1,
+		Receive:     t1.Add(time.Second + 10*time.Millisecond),
+		Transmit:    t1.Add(time.Second + 15*time.Millisecond),
+		Destination: t1.Add(45 * time.Millisecond),
+	}
+	if want, got := 990*time.Millisecond, s.Offset(); want != got {
+		t.Errorf("want offset %v, got %v", want, got)
+	}
+	if want, got := 40*time.Millisecond, s.Delay(); want != got {
+		t.Errorf("want delay %v, got %v", want, got)
+	}
+}
+
+func TestSyncer_EstimatesOffsetFromServer(t *testing.T) {
+	t.Parallel()
+
+	for _, network := range []string{"tcp", "udp"} {
+		network := network
+		t.Run(network, func(t *testing.T) {
+			t.Parallel()
+
+			conn := listenAndDial(t, &clock.Server{Interval: 10 * time.Millisecond}, network)
+			s := clock.Syncer{Network: network, Addr: conn.RemoteAddr().String(), Samples: 5}
+			est, err := s.Sync(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(est.Samples) != 5 {
+				t.Errorf("want 5 samples, got %d", len(est.Samples))
+			}
+			// the server runs on the same clock
+			if est.Offset < -est.Delay || est.Offset > est.Delay {
+				t.Errorf("want offset within delay %v, got %v", est.Delay, est.Offset)
+			}
+			if est.Delay < 0 || est.Delay > time.Second {
+				t.Errorf("want delay of a local exchange, got %v", est.Delay)
+			}
+		})
+	}
+}
+
+func TestSyncer_FailsWhenNoAnswersArrive(t *testing.T) {
+	t.Parallel()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s := clock.Syncer{Network: "udp", Addr: pc.LocalAddr().String(), Samples: 2, Timeout: 20 * time.Millisecond}
+	_, err = s.Sync(context.Background())
+	if !errors.Is(err, clock.ErrNoSamples) {
+		t.Errorf("want ErrNoSamples, got %v", err)
+	}
+}
+
+func TestServer_RejectsInvalidSyncTimestamp(t *testing.T) {
+	t.Parallel()
+
+	conn := listenAndDial(t, &clock.Server{}, "udp")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("sync yesterday\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "error: invalid timestamp \"yesterday\"\n", string(buf[:n]); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
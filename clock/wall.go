@@ -0,0 +1,206 @@
+package clock
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =========
+// Clock wall
+// =========
+
+// Clock is a clock server shown on the wall.
+type Clock struct {
+	// Name heading the clock's column.
+	Name string
+
+	// Addr of the clock server, TCP host:port.
+	Addr string
+
+	// Zone the clock shows the time in, an IANA time zone name.
+	// The server's default zone is used if empty.
+	Zone string
+}
+
+// Wall connects to several clock servers and shows their
+// times side by side, one table row every Interval:
+//
+//	Warsaw    Tokyo     NewYork
+//	14:02:11  21:02:11  08:02:11
+//
+// A clock which can't be reached is shown as "down" and
+// redialled until it is back. A clock rejecting the zone
+// or layout is shown as "error".
+type Wall struct {
+	Clocks []Clock
+
+	// Output the table is written to, os.Stdout if nil.
+	Output io.Writer
+
+	// Interval between rows, 1s if zero.
+	Interval time.Duration
+
+	// Layout the clocks are asked to show the time in,
+	// "15:04:05" if empty.
+	Layout string
+
+	mu    sync.Mutex
+	times []string
+}
+
+// Run shows the clocks until ctx is cancelled.
+func (w *Wall) Run(ctx context.Context) error {
+	if len(w.Clocks) == 0 {
+		return fmt.Errorf("no clocks to show")
+	}
+	out := w.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	interval, layout := w.Interval, w.Layout
+	if interval == 0 {
+		interval = time.Second
+	}
+	if layout == "" {
+		layout = "15:04:05"
+	}
+
+	// named formats, like unix or rfc3339, are not as wide as their names
+	st := settings{loc: time.Local}
+	if err := st.apply("format " + layout); err != nil {
+		return err
+	}
+	sample := strings.TrimSuffix(st.format(time.Now()), "\n")
+
+	w.times = make([]string, len(w.Clocks))
+	widths := make([]int, len(w.Clocks))
+	names := make([]string, len(w.Clocks))
+	for i, c := range w.Clocks {
+		w.times[i] = "down"
+		widths[i] = len(c.Name)
+		for _, n := range []int{len(sample), len("error")} {
+			if n > widths[i] {
+				widths[i] = n
+			}
+		}
+		names[i] = c.Name
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range w.Clocks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.watch(ctx, i, interval, layout)
+		}(i)
+	}
+
+	if _, err := fmt.Fprintln(out, row(names, widths)); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.mu.Lock()
+			line := row(w.times, widths)
+			w.mu.Unlock()
+			if _, err := fmt.Fprintln(out, line); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// row lays out the cells in columns of the widths.
+func row(cells []string, widths []int) string {
+	var b strings.Builder
+	for i, c := range cells {
+		if i > 0 {
+			b.WriteString("  ")
+		}
+		fmt.Fprintf(&b, "%-*s", widths[i], c)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// watch keeps the time of the i-th clock up to date,
+// redialling the server when the connection is lost.
+func (w *Wall) watch(ctx context.Context, i int, interval time.Duration, layout string) {
+	for {
+		err := w.follow(ctx, i, interval, layout)
+		if errors.Is(err, errRefused) {
+			// asking again won't help, keep showing the error
+			return
+		}
+		w.set(i, "down")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// errRefused means the clock server rejected the wall's commands.
+var errRefused = errors.New("clock refused commands")
+
+// follow reads the time from the i-th clock until
+// the connection fails or ctx is cancelled.
+func (w *Wall) follow(ctx context.Context, i int, interval time.Duration, layout string) error {
+	c := w.Clocks[i]
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// unblock the reader when the wall stops
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var cmds strings.Builder
+	if c.Zone != "" {
+		fmt.Fprintf(&cmds, "tz %s\n", c.Zone)
+	}
+	fmt.Fprintf(&cmds, "format %s\ninterval %v\n", layout, interval)
+	if _, err := io.WriteString(conn, cmds.String()); err != nil {
+		return err
+	}
+
+	input := bufio.NewScanner(conn)
+	for input.Scan() {
+		line := input.Text()
+		if msg, ok := strings.CutPrefix(line, "error: "); ok {
+			w.set(i, "error")
+			return fmt.Errorf("%w: %s", errRefused, msg)
+		}
+		w.set(i, line)
+	}
+	return input.Err()
+}
+
+func (w *Wall) set(i int, value string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.times[i] = value
+}
@@ -1,15 +1,59 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"time"
+	_ "time/tzdata" // clients can select zones on hosts without tz database
 
 	"github.com/qba73/gocp/clock"
 )
 
 func main() {
-	if err := clock.RunClock(); err != nil {
+	network := flag.String("network", "tcp", "network to serve on: tcp, unix, udp or unixgram")
+	addr := flag.String("addr", "localhost:9000", "address, or socket path for unix networks, to listen on")
+	interval := flag.Duration("interval", 5*time.Second, "default interval between time updates")
+	tz := flag.String("tz", "Local", "default IANA time zone")
+	layout := flag.String("format", "15:04:05", "default Go time layout")
+	maxConns := flag.Int("max-conns", 0, "maximum number of connected clients, no limit if 0")
+	idle := flag.Duration("idle-timeout", 0, "disconnect clients sending nothing for that long, disabled if 0")
+	flag.Parse()
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	s := clock.Server{
+		Network:     *network,
+		Addr:        *addr,
+		Interval:    *interval,
+		Location:    loc,
+		Layout:      *layout,
+		MaxConns:    *maxConns,
+		IdleTimeout: *idle,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if err := s.ListenAndServe(); err != nil && !errors.Is(err, clock.ErrServerClosed) {
 		log.Println(err)
 		os.Exit(1)
 	}
+	<-shutdown // let the clients disconnect
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/qba73/gocp/clock"
+)
+
+func main() {
+	network := flag.String("network", "tcp", "network to sync over: tcp, unix or udp")
+	addr := flag.String("addr", "localhost:9000", "address, or socket path for unix, of the clock server")
+	samples := flag.Int("samples", 8, "number of timestamp exchanges to average")
+	timeout := flag.Duration("timeout", time.Second, "time to wait for a single answer")
+	verbose := flag.Bool("v", false, "print every sample")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	s := clock.Syncer{Network: *network, Addr: *addr, Samples: *samples, Timeout: *timeout}
+	est, err := s.Sync(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *verbose {
+		for i, sample := range est.Samples {
+			fmt.Printf("sample %d: offset %v delay %v\n", i+1, sample.Offset(), sample.Delay())
+		}
+	}
+	fmt.Printf("offset %v delay %v (%d of %d samples)\n", est.Offset, est.Delay, len(est.Samples), *samples)
+}
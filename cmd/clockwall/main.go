@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/qba73/gocp/clock"
+)
+
+func main() {
+	interval := flag.Duration("interval", time.Second, "interval between rows")
+	layout := flag.String("format", "15:04:05", "time layout: rfc3339, unix, kitchen or a Go time layout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] NAME=ADDR[=ZONE]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	w := clock.Wall{Interval: *interval, Layout: *layout}
+	for _, arg := range flag.Args() {
+		parts := strings.SplitN(arg, "=", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			log.Fatalf("invalid clock %q, want NAME=ADDR[=ZONE]", arg)
+		}
+		c := clock.Clock{Name: parts[0], Addr: parts[1]}
+		if len(parts) == 3 {
+			c.Zone = parts[2]
+		}
+		w.Clocks = append(w.Clocks, c)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := w.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
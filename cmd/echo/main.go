@@ -1,13 +1,73 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/qba73/gocp/echo"
 )
 
 func main() {
-	if err := echo.Run(); err != nil {
+	network := flag.String("network", "tcp", "network to serve on: tcp, unix, udp or unixgram")
+	addr := flag.String("addr", "localhost:9000", "address, or socket path for unix networks, to listen on")
+	delay := flag.Duration("delay", time.Second, "delay between echoes")
+	transforms := flag.String("transforms", "upper,title,lower", "comma separated echo transforms: upper, lower, title, reverse, rot13, repeatN; join with + to chain, e.g. reverse+upper")
+	ordered := flag.Bool("ordered", false, "echo lines one by one instead of interleaving echoes")
+	maxInFlight := flag.Int("max-in-flight", echo.DefaultMaxInFlight, "max number of lines echoed concurrently per connection")
+	flag.Parse()
+
+	ts, err := parseTransforms(*transforms)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s := echo.Server{Network: *network, Addr: *addr, Delay: *delay, Transforms: ts, MaxInFlight: *maxInFlight}
+	if *ordered {
+		s.Mode = echo.Ordered
+	}
+	if err := s.ListenAndServe(); err != nil {
 		log.Fatalln(err)
 	}
 }
+
+func parseTransforms(spec string) ([]echo.Transform, error) {
+	var ts []echo.Transform
+	for _, item := range strings.Split(spec, ",") {
+		var chain []echo.Transform
+		for _, name := range strings.Split(item, "+") {
+			t, err := transform(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, t)
+		}
+		ts = append(ts, echo.Chain(chain...))
+	}
+	return ts, nil
+}
+
+func transform(name string) (echo.Transform, error) {
+	switch name {
+	case "upper":
+		return echo.Upper, nil
+	case "lower":
+		return echo.Lower, nil
+	case "title":
+		return echo.Title, nil
+	case "reverse":
+		return echo.Reverse, nil
+	case "rot13":
+		return echo.Rot13, nil
+	}
+	if count, ok := strings.CutPrefix(name, "repeat"); ok {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid repeat count in %q", name)
+		}
+		return echo.Repeat(n), nil
+	}
+	return nil, fmt.Errorf("unknown transform %q", name)
+}
@@ -1,34 +1,45 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
-	"io"
 	"log"
-	"net"
 	"os"
+	"os/signal"
+
+	"github.com/qba73/gocp/icq"
 )
 
 func main() {
-	port := flag.String("port", "8000", "port to connect to")
+	addr := flag.String("addr", "localhost:8000", "address of the ICQ server")
+	nick := flag.String("nick", os.Getenv("USER"), "nickname to use in the chat")
+	timestamps := flag.Bool("timestamps", true, "prefix messages with the time they were received")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	ca := flag.String("ca", "", "PEM file with certificates to trust, e.g. a self-signed server certificate")
 	flag.Parse()
 
-	conn, err := net.Dial("tcp", "localhost:"+*port)
-	if err != nil {
-		log.Fatal(err)
-	}
-	done := make(chan bool)
-	go func() {
-		io.Copy(os.Stdout, conn)
-		log.Println("done")
-		done <- true
-	}()
-	mustCopy(conn, os.Stdin)
-	conn.Close()
-	<-done
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-func mustCopy(dst io.Writer, src io.Reader) {
-	if _, err := io.Copy(dst, src); err != nil {
+	c := icq.Client{
+		Addr:       *addr,
+		Nick:       *nick,
+		Secret:     os.Getenv("ICQ_SECRET"),
+		Output:     os.Stdout,
+		Timestamps: *timestamps,
+	}
+	if *useTLS || *ca != "" {
+		c.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if *ca != "" {
+			pool, err := icq.LoadCertPool(*ca)
+			if err != nil {
+				log.Fatal(err)
+			}
+			c.TLSConfig.RootCAs = pool
+		}
+	}
+	if err := c.Run(ctx, os.Stdin); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -1,7 +1,153 @@
 package main
 
-import "github.com/qba73/gocp/icq"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/qba73/gocp/icq"
+)
 
 func main() {
-	icq.RunServer()
+	addr := flag.String("addr", "localhost:8000", "address to listen on")
+	history := flag.Int("history", 100, "number of recent messages kept per room")
+	historyFile := flag.String("history-file", "", "append-only file to persist messages to")
+	httpAddr := flag.String("http", "", "address to serve the websocket gateway on, disabled if empty")
+	certFile := flag.String("cert", "", "TLS certificate file, enables TLS")
+	keyFile := flag.String("key", "", "TLS private key file")
+	genCert := flag.Bool("gen-cert", false, "generate a self-signed certificate for localhost if -cert and -key files don't exist")
+	passwords := flag.String("passwords", "", "file with user:password entries clients authenticate with")
+	tokens := flag.String("tokens", "", "file with user:token entries clients authenticate with")
+	rate := flag.Float64("rate", 0, "messages per second a client can post, no limit if 0")
+	burst := flag.Int("burst", 5, "number of messages a client can post in a burst")
+	maxLine := flag.Int("max-line", 0, "longest message in bytes, no limit if 0")
+	operators := flag.String("operators", "", "comma separated nicks of operators")
+	name := flag.String("name", "", "unique name of the server among linked servers, defaults to -addr")
+	linkAddr := flag.String("link", "", "address to accept links from other servers on, disabled if empty")
+	peers := flag.String("peers", "", "comma separated link addresses of servers to link with")
+	linkSecretFile := flag.String("link-secret-file", "", "file with the secret shared by linked servers, required with -link or -peers")
+	linkCA := flag.String("link-ca", "", "CA certificate file of linked servers, with -cert and -key links use mutual TLS")
+	bots := flag.String("bots", "", "comma separated built-in bots to attach to the lobby: echo, clock, commands")
+	heartbeat := flag.Duration("heartbeat", 0, "interval of pinging clients to detect dead peers, disabled if 0")
+	flag.Parse()
+
+	s := icq.Server{
+		Addr:          *addr,
+		HistorySize:   *history,
+		HistoryFile:   *historyFile,
+		RateLimit:     *rate,
+		RateBurst:     *burst,
+		MaxLineLength: *maxLine,
+		Name:          *name,
+		LinkAddr:      *linkAddr,
+
+		HeartbeatInterval: *heartbeat,
+	}
+	if *peers != "" {
+		s.Peers = strings.Split(*peers, ",")
+	}
+	if *operators != "" {
+		s.Operators = strings.Split(*operators, ",")
+	}
+
+	if *certFile != "" {
+		if *genCert {
+			if _, err := os.Stat(*certFile); errors.Is(err, fs.ErrNotExist) {
+				if err := icq.GenerateCert(*certFile, *keyFile, "localhost", "127.0.0.1", "::1"); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		cfg, err := icq.LoadTLSConfig(*certFile, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.TLSConfig = cfg
+	}
+
+	if *linkSecretFile != "" {
+		secret, err := os.ReadFile(*linkSecretFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.LinkSecret = strings.TrimSpace(string(secret))
+	}
+	if (s.LinkAddr != "" || s.Peers != nil) && s.LinkSecret == "" {
+		log.Fatal("-link and -peers require -link-secret-file")
+	}
+	if *linkCA != "" {
+		if *certFile == "" || *keyFile == "" {
+			log.Fatal("-link-ca requires -cert and -key")
+		}
+		cfg, err := icq.LoadLinkTLSConfig(*certFile, *keyFile, *linkCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.LinkTLSConfig = cfg
+	}
+
+	switch {
+	case *passwords != "" && *tokens != "":
+		log.Fatal("use either -passwords or -tokens")
+	case *passwords != "":
+		p, err := icq.LoadPasswordFile(*passwords)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Auth = p
+	case *tokens != "":
+		t, err := icq.LoadTokenFile(*tokens)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Auth = t
+	}
+
+	if s.Operators != nil && s.Auth == nil {
+		log.Fatal("-operators requires -passwords or -tokens, anyone can take an operator's nick otherwise")
+	}
+
+	if *bots != "" {
+		for _, name := range strings.Split(*bots, ",") {
+			bot, err := builtinBot(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			s.Bots = append(s.Bots, bot)
+		}
+	}
+
+	if *httpAddr != "" {
+		go func() {
+			log.Fatal(s.ListenAndServeHTTP(*httpAddr))
+		}()
+	}
+	if err := s.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func builtinBot(name string) (icq.Bot, error) {
+	switch name {
+	case "echo":
+		return icq.NewEchoBot("echo", icq.DefaultRoom), nil
+	case "clock":
+		return icq.NewClockBot("clock", time.Hour, icq.DefaultRoom), nil
+	case "commands":
+		started := time.Now()
+		bot := icq.NewCommandBot("butler", icq.DefaultRoom)
+		bot.Handle("time", func(args []string, m icq.Message) string {
+			return time.Now().Format(time.RFC1123)
+		})
+		bot.Handle("uptime", func(args []string, m icq.Message) string {
+			return "up for " + time.Since(started).Round(time.Second).String()
+		})
+		return bot, nil
+	}
+	return nil, fmt.Errorf("unknown bot %q", name)
 }
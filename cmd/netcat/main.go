@@ -1,9 +1,127 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+
 	"github.com/qba73/gocp/netcat"
 )
 
+// Exit codes.
+const (
+	exitOK    = 0
+	exitConn  = 1 // the connection failed or broke
+	exitUsage = 2
+)
+
 func main() {
-	netcat.Run()
+	os.Exit(run())
+}
+
+func run() int {
+	var c netcat.Config
+	flag.StringVar(&c.Network, "network", "tcp", "network: tcp, tcp4, tcp6 or unix")
+	flag.StringVar(&c.Addr, "addr", "localhost:9000", "address, or socket path for unix, to connect to or listen on")
+	flag.BoolVar(&c.Listen, "l", false, "listen for a single connection instead of connecting")
+	flag.DurationVar(&c.Timeout, "timeout", 0, "time to wait for the connection, no limit if 0")
+	flag.DurationVar(&c.IdleTimeout, "idle-timeout", 0, "end the session after no data flows for that long, no limit if 0")
+	target := flag.String("relay", "", "forward connections accepted on -addr to this address instead of using stdin and stdout")
+	dump := flag.String("dump", "", "file to append a hex dump of relayed traffic to")
+	zero := flag.Bool("z", false, "scan the ports of the -addr host for listening services instead of sending data")
+	ports := flag.String("ports", "", "ports to scan with -z, e.g. 22,80,8000-9000, the -addr port if empty")
+	workers := flag.Int("workers", 100, "number of ports probed at once with -z")
+	verbose := flag.Bool("v", false, "report closed and filtered ports with -z too")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\nexit status is 0 on success, 1 if the connection failed or broke and 2 on usage errors;\nwith -z it is 0 if any port is open and 1 if none is; it is 0 when stopped with Ctrl-C")
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		return exitUsage
+	}
+
+	if *dump != "" && *target == "" {
+		fmt.Fprintln(os.Stderr, "netcat: -dump needs -relay")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *zero {
+		host, port, err := net.SplitHostPort(c.Addr)
+		if err != nil {
+			host, port = c.Addr, ""
+		}
+		if *ports == "" {
+			*ports = port
+		}
+		list, err := netcat.ParsePorts(*ports)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "netcat:", err)
+			return exitUsage
+		}
+		sc := netcat.Scanner{Network: c.Network, Host: host, Workers: *workers, Timeout: c.Timeout}
+		return scan(ctx, &sc, list, *verbose)
+	}
+
+	if *target != "" {
+		r := netcat.Relay{Network: c.Network, Addr: c.Addr, Target: *target, Timeout: c.Timeout}
+		if *dump != "" {
+			f, err := os.OpenFile(*dump, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "netcat:", err)
+				return exitUsage
+			}
+			defer f.Close()
+			r.Dump = f
+		}
+		if err := r.Run(ctx); err != nil && !interrupted(err) {
+			fmt.Fprintln(os.Stderr, "netcat:", err)
+			return exitConn
+		}
+		return exitOK
+	}
+
+	if err := c.Run(ctx); err != nil && !interrupted(err) {
+		fmt.Fprintln(os.Stderr, "netcat:", err)
+		return exitConn
+	}
+	return exitOK
+}
+
+// interrupted reports whether the error is Ctrl-C
+// ending the session, a normal way to stop.
+func interrupted(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// scan reports the state of the ports and returns exitOK
+// if any of them is open.
+func scan(ctx context.Context, sc *netcat.Scanner, ports []int, verbose bool) int {
+	results, err := sc.Scan(ctx, ports)
+	if interrupted(err) {
+		return exitOK
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "netcat:", err)
+		return exitConn
+	}
+	code := exitConn
+	for _, r := range results {
+		if r.State == netcat.Open {
+			code = exitOK
+		}
+		if r.State == netcat.Open || verbose {
+			fmt.Printf("%s:%d %s\n", sc.Host, r.Port, r.State)
+		}
+	}
+	return code
 }
@@ -1,7 +0,0 @@
-package main
-
-import "github.com/qba73/gocp/netcat3"
-
-func main() {
-	netcat3.Run()
-}
@@ -1,7 +1,221 @@
 package main
 
-import "github.com/qba73/gocp/prodline"
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qba73/gocp/prodline"
+)
 
 func main() {
-	prodline.RunBakery()
+	simulate := flag.Bool("simulate", false, "simulate in virtual time instead of running in real time")
+	seed := flag.Int64("seed", 0, "seed of the simulation, the current time if 0")
+	runs := flag.Int("runs", 1, "number of runs")
+	cakes := flag.Int("cakes", 0, "number of cakes per run, the configured number if 0")
+	config := flag.String("config", "", "JSON or YAML file with the bakery configuration, the demo bakery if empty")
+	out := flag.String("out", "", "directory to write the results, the effective configuration and the sweep flags to, stdout if empty")
+	dashboard := flag.Bool("dashboard", false, "show a live view of the line while it works in real time")
+
+	sweep := flag.Bool("sweep", false, "simulate every combination of the parameter ranges and report the best")
+	icers := flag.String("icers", "", "range of icers to sweep: N, MIN-MAX or MIN-MAX:STEP")
+	inscribers := flag.String("inscribers", "", "range of inscribers to sweep")
+	packers := flag.String("packers", "", "range of packers to sweep")
+	bakeBuf := flag.String("bake-buf", "", "range of baked cakes buffer sizes to sweep")
+	iceBuf := flag.String("ice-buf", "", "range of iced cakes buffer sizes to sweep")
+	inscribeBuf := flag.String("inscribe-buf", "", "range of inscribed cakes buffer sizes to sweep")
+	packerBuf := flag.String("packer-buf", "", "range of packed cakes buffer sizes to sweep")
+	target := flag.Duration("target-latency", 0, "pick the fewest workers keeping the mean cake latency within this, instead of the best throughput per worker")
+	csvFile := flag.String("csv", "", "file to write the sweep results to as CSV, - for stdout")
+	flag.Parse()
+
+	b := prodline.DemoBakery()
+	format := prodline.YAML
+	if *config != "" {
+		var err error
+		if b, err = prodline.LoadConfig(*config); err != nil {
+			log.Fatal(err)
+		}
+		if format, err = prodline.FormatOf(*config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *cakes > 0 {
+		b.Cakes = *cakes
+	}
+	if *dashboard && (*simulate || *sweep) {
+		log.Fatal("-dashboard shows the line working in real time, it can't be used with -simulate or -sweep")
+	}
+
+	// results go to stdout or, with the effective configuration, to -out
+	results := io.Writer(os.Stdout)
+	if *out != "" {
+		if *sweep && *csvFile == "" {
+			*csvFile = filepath.Join(*out, "sweep.csv")
+		}
+		f, err := writeResults(*out, b, format)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		results = f
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	if *sweep {
+		s := prodline.Sweep{Base: b, Runs: *runs, Seed: *seed}
+		sweepFlags := []string{
+			fmt.Sprintf("-runs %d", *runs),
+			fmt.Sprintf("-seed %d", *seed),
+			fmt.Sprintf("-target-latency %v", *target),
+		}
+		for _, r := range []struct {
+			flag  string
+			value string
+			dst   *prodline.Range
+		}{
+			{"icers", *icers, &s.Icers},
+			{"inscribers", *inscribers, &s.Inscribers},
+			{"packers", *packers, &s.Packers},
+			{"bake-buf", *bakeBuf, &s.BakeBuf},
+			{"ice-buf", *iceBuf, &s.IceBuf},
+			{"inscribe-buf", *inscribeBuf, &s.InscribeBuf},
+			{"packer-buf", *packerBuf, &s.PackerBuf},
+		} {
+			if r.value == "" {
+				continue
+			}
+			rng, err := prodline.ParseRange(r.value)
+			if err != nil {
+				log.Fatalf("-%s: %v", r.flag, err)
+			}
+			*r.dst = rng
+			sweepFlags = append(sweepFlags, "-"+r.flag+" "+r.value)
+		}
+		if *out != "" {
+			if err := writeSweep(*out, sweepFlags); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := runSweep(&s, *target, *csvFile, results); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *dashboard {
+		for _, r := range watch(b, *runs) {
+			fmt.Fprint(results, r)
+		}
+		return
+	}
+	if !*simulate {
+		reports, err := b.Work(*runs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, r := range reports {
+			fmt.Fprint(results, r)
+		}
+		return
+	}
+	reports, err := b.Simulate(*runs, *seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(results, "seed", *seed)
+	for _, r := range reports {
+		fmt.Fprint(results, r)
+	}
+}
+
+// watch runs the bakery showing its line on the dashboard.
+func watch(b prodline.Bakery, runs int) []prodline.Report {
+	// the dashboard replaces the println output
+	b.Verbose = false
+	d := &prodline.Dashboard{Bakery: b}
+	b.Events = d.Observe
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	reports, err := b.Work(runs)
+	cancel()
+	if err := <-done; err != nil {
+		log.Fatal(err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return reports
+}
+
+// writeResults writes the effective configuration to the directory
+// and returns the file to write the results to.
+func writeResults(dir string, b prodline.Bakery, format string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cfg, err := os.Create(filepath.Join(dir, "config."+format))
+	if err != nil {
+		return nil, err
+	}
+	if err := prodline.WriteConfig(cfg, b, format); err != nil {
+		cfg.Close()
+		return nil, err
+	}
+	if err := cfg.Close(); err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(dir, "results.txt"))
+}
+
+// writeSweep writes the flags of the sweep, one per line, to
+// the directory, so that with the configuration written there
+// the sweep can be repeated.
+func writeSweep(dir string, flags []string) error {
+	return os.WriteFile(filepath.Join(dir, "sweep.txt"), []byte(strings.Join(flags, "\n")+"\n"), 0o644)
+}
+
+// runSweep runs the sweep, writes its results to the CSV file
+// and reports the best configuration to w.
+func runSweep(s *prodline.Sweep, target time.Duration, csvFile string, w io.Writer) error {
+	results, err := s.Run()
+	if err != nil {
+		return err
+	}
+	if csvFile != "" {
+		var csvw io.Writer = os.Stdout
+		if csvFile != "-" {
+			f, err := os.Create(csvFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			csvw = f
+		}
+		if err := prodline.WriteCSV(csvw, results); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "simulated %d configurations, seed %d\n", len(results), s.Seed)
+	best, ok := prodline.Best(results, target)
+	if !ok {
+		return fmt.Errorf("no configuration keeps the latency within %v", target)
+	}
+	b := best.Bakery
+	fmt.Fprintf(w, "best: icers %d, inscribers %d, packers %d, buffers %d/%d/%d/%d\n",
+		b.NumIcers, b.NumInscribers, b.NumPackers, b.BakeBuf, b.IceBuf, b.InscribeBuf, b.PackerBuf)
+	fmt.Fprintf(w, "      %d workers, %.4f cakes/s, %.4f cakes/s per worker, latency %v\n",
+		best.Workers, best.Throughput, best.PerWorker, best.Latency.Round(time.Millisecond))
+	return nil
 }
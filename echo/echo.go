@@ -2,54 +2,322 @@ package echo
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-func Run() error {
-	listener, err := net.Listen("tcp", "localhost:9000")
+// Transform changes the shout before it echoes back.
+type Transform func(string) string
+
+// Mode defines how echoes of consecutive lines are written.
+type Mode int
+
+const (
+	// Interleaved echoes lines concurrently, so echoes
+	// of consecutive lines interleave with each other.
+	Interleaved Mode = iota
+
+	// Ordered echoes lines one by one, in the order they came.
+	// Datagram servers echo the lines of each datagram in order,
+	// but datagrams themselves may arrive in any order.
+	Ordered
+)
+
+// DefaultMaxInFlight is a default number of lines
+// echoed concurrently on a single connection.
+const DefaultMaxInFlight = 16
+
+// Server is a line based echo server. Every line a client
+// sends is echoed back once per transform, with a delay between
+// the echoes, like a shout echoing in the mountains.
+type Server struct {
+	// Network is "tcp" (default), "tcp4", "tcp6" or "unix" for stream
+	// transports and "udp", "udp4", "udp6" or "unixgram" for datagrams.
+	Network string
+
+	// Addr is an address to listen on, "localhost:9000" if empty.
+	// For unix networks it is a path of the socket file.
+	Addr string
+
+	// Delay between consecutive echoes of a line.
+	Delay time.Duration
+
+	// Transforms applied to the line, one echo per transform.
+	// Upper, Title and Lower are used if empty.
+	Transforms []Transform
+
+	Mode Mode
+
+	// MaxInFlight limits the number of lines being echoed on
+	// a connection. When the limit is reached the server stops
+	// reading from the client until an echo finishes. Datagram
+	// servers apply the limit to all clients together.
+	// DefaultMaxInFlight is used if zero.
+	MaxInFlight int
+
+	Logger *log.Logger
+}
+
+// DefaultTransforms echo the shout loud first and then fading out.
+var DefaultTransforms = []Transform{Upper, Title, Lower}
+
+// ListenAndServe listens on s.Network address s.Addr and then calls
+// Serve or ServePacket, depending on the network, to handle clients.
+func (s *Server) ListenAndServe() error {
+	network, addr := s.Network, s.Addr
+	if network == "" {
+		network = "tcp"
+	}
+	if addr == "" {
+		addr = "localhost:9000"
+	}
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		return s.ServePacket(pc)
+	}
+	l, err := net.Listen(network, addr)
 	if err != nil {
 		return err
 	}
+	return s.Serve(l)
+}
+
+// Serve accepts incoming connections on the listener l,
+// creating a new handleConn goroutine for each.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.logf("%v", err)
+				continue
+			}
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
 
+// ServePacket reads datagrams from pc. Every line of a datagram
+// is echoed back to the sender, each echo in a separate datagram.
+func (s *Server) ServePacket(pc net.PacketConn) error {
+	sem := make(chan struct{}, s.maxInFlight())
+	buf := make([]byte, 64*1024)
 	for {
-		conn, err := listener.Accept()
+		n, addr, err := pc.ReadFrom(buf)
 		if err != nil {
-			log.Println(err)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.logf("%v", err)
+				continue
+			}
+			return err
+		}
+		dg := datagram{pc: pc, addr: addr}
+		input := bufio.NewScanner(bytes.NewReader(buf[:n]))
+		if s.Mode == Ordered {
+			// a goroutine per datagram echoes its lines one by one
+			var shouts []string
+			for input.Scan() {
+				shouts = append(shouts, input.Text())
+			}
+			sem <- struct{}{}
+			go func() {
+				for _, shout := range shouts {
+					s.echo(dg, shout)
+				}
+				<-sem
+			}()
 			continue
 		}
-		go handleConn(conn)
+		for input.Scan() {
+			sem <- struct{}{}
+			go func(shout string) {
+				s.echo(dg, shout)
+				<-sem
+			}(input.Text())
+		}
 	}
 }
 
-func echo(c net.Conn, shout string, delay time.Duration) {
-	fmt.Fprintln(c, "\t", strings.ToUpper(shout))
-	time.Sleep(delay)
-	fmt.Fprintln(c, "\t", toTitle(shout))
-	time.Sleep(delay)
-	fmt.Fprintln(c, "\t", strings.ToLower(shout))
-	time.Sleep(delay)
-	fmt.Fprintln(c, "\t", "--- --- ---")
+func (s *Server) maxInFlight() int {
+	if s.MaxInFlight <= 0 {
+		return DefaultMaxInFlight
+	}
+	return s.MaxInFlight
 }
 
-func toTitle(s string) string {
-	if s == "" {
-		return s
+func (s *Server) logf(format string, v ...any) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+		return
 	}
-	first := strings.ToUpper(string(s[0]))
-	return first + strings.ToLower(s[1:])
+	log.Printf(format, v...)
+}
+
+// lineWriter writes echoed lines back to the client.
+type lineWriter interface {
+	println(s string)
 }
 
-func handleConn(c net.Conn) {
+// conn serializes writes of concurrent echoes, so each
+// echoed line is written to the connection as a whole.
+type conn struct {
+	mu sync.Mutex
+	net.Conn
+}
+
+func (c *conn) println(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintln(c.Conn, "\t", s)
+}
+
+// datagram writes echoed lines to the client's address.
+type datagram struct {
+	pc   net.PacketConn
+	addr net.Addr
+}
+
+func (d datagram) println(s string) {
+	d.pc.WriteTo([]byte(fmt.Sprintln("\t", s)), d.addr)
+}
+
+func (s *Server) echo(c lineWriter, shout string) {
+	transforms := s.Transforms
+	if len(transforms) == 0 {
+		transforms = DefaultTransforms
+	}
+	for _, t := range transforms {
+		c.println(t(shout))
+		time.Sleep(s.Delay)
+	}
+	c.println("--- --- ---")
+}
+
+// handleConn echoes lines sent by the client. When the client
+// stops sending, it waits for pending echoes before closing
+// the connection.
+func (s *Server) handleConn(nc net.Conn) {
+	c := &conn{Conn: nc}
 	defer c.Close()
+
+	limit := s.maxInFlight()
+
+	var wg sync.WaitGroup
 	input := bufio.NewScanner(c)
-	for input.Scan() {
-		go echo(c, input.Text(), 1*time.Second)
+	switch s.Mode {
+	case Ordered:
+		// a single goroutine echoes queued lines one by one
+		queue := make(chan string, limit-1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shout := range queue {
+				s.echo(c, shout)
+			}
+		}()
+		for input.Scan() {
+			queue <- input.Text()
+		}
+		close(queue)
+	default:
+		// a goroutine per line, at most limit at a time
+		sem := make(chan struct{}, limit)
+		for input.Scan() {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(shout string) {
+				defer wg.Done()
+				s.echo(c, shout)
+				<-sem
+			}(input.Text())
+		}
 	}
 	if err := input.Err(); err != nil {
-		log.Println(err)
+		s.logf("%v", err)
+	}
+	wg.Wait()
+}
+
+// Run starts the echo server on localhost:9000.
+func Run() error {
+	s := Server{Delay: 1 * time.Second}
+	return s.ListenAndServe()
+}
+
+// Upper returns the shout in upper case.
+func Upper(s string) string {
+	return strings.ToUpper(s)
+}
+
+// Lower returns the shout in lower case.
+func Lower(s string) string {
+	return strings.ToLower(s)
+}
+
+// Title returns the shout with the first letter in upper case
+// and the rest in lower case.
+func Title(s string) string {
+	if s == "" {
+		return s
+	}
+	first, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToTitle(first)) + strings.ToLower(s[size:])
+}
+
+// Reverse returns the shout backwards.
+func Reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// Rot13 returns the shout with latin letters rotated by 13 places.
+func Rot13(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, s)
+}
+
+// Repeat returns a transform repeating the shout n times.
+func Repeat(n int) Transform {
+	return func(s string) string {
+		if n <= 0 {
+			return ""
+		}
+		shouts := make([]string, n)
+		for i := range shouts {
+			shouts[i] = s
+		}
+		return strings.Join(shouts, " ")
+	}
+}
+
+// Chain returns a transform applying the transforms in order.
+func Chain(transforms ...Transform) Transform {
+	return func(s string) string {
+		for _, t := range transforms {
+			s = t(s)
+		}
+		return s
 	}
 }
@@ -0,0 +1,288 @@
+package echo_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/echo"
+)
+
+func startServer(t *testing.T, s *echo.Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+func shout(t *testing.T, addr, line string, echoes int) []string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprintln(conn, line)
+
+	var got []string
+	input := bufio.NewScanner(conn)
+	for i := 0; i < echoes && input.Scan(); i++ {
+		got = append(got, input.Text())
+	}
+	if err := input.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestServer_EchoesWithDefaultTransforms(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &echo.Server{})
+	want := []string{"\t HELLO", "\t Hello", "\t hello", "\t --- --- ---"}
+	got := shout(t, addr, "hEllo", 4)
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_EchoesWithConfiguredTransforms(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &echo.Server{
+		Delay:      time.Millisecond,
+		Transforms: []echo.Transform{echo.Reverse, echo.Rot13, echo.Chain(echo.Upper, echo.Repeat(2))},
+	})
+	want := []string{"\t olleh", "\t uryyb", "\t HELLO HELLO", "\t --- --- ---"}
+	got := shout(t, addr, "hello", 4)
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		transform echo.Transform
+		in, want  string
+	}{
+		{name: "upper", transform: echo.Upper, in: "Go gopher", want: "GO GOPHER"},
+		{name: "lower", transform: echo.Lower, in: "Go Gopher", want: "go gopher"},
+		{name: "title", transform: echo.Title, in: "gO GOPHER", want: "Go gopher"},
+		{name: "title of empty", transform: echo.Title, in: "", want: ""},
+		{name: "title of non-ASCII", transform: echo.Title, in: "éLAN", want: "Élan"},
+		{name: "reverse", transform: echo.Reverse, in: "gopher ż", want: "ż rehpog"},
+		{name: "rot13", transform: echo.Rot13, in: "Hello, World!", want: "Uryyb, Jbeyq!"},
+		{name: "rot13 twice", transform: echo.Chain(echo.Rot13, echo.Rot13), in: "Gopher", want: "Gopher"},
+		{name: "repeat", transform: echo.Repeat(3), in: "go go", want: "go go go go go go"},
+	}
+	for _, tc := range tests {
+		if got := tc.transform(tc.in); got != tc.want {
+			t.Errorf("%s(%q): want %q, got %q", tc.name, tc.in, tc.want, got)
+		}
+	}
+}
+
+// shoutAll sends all lines, closes the writing side of the connection
+// and returns everything the server echoed until it closed the connection.
+func shoutAll(t *testing.T, addr string, lines ...string) []string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for _, l := range lines {
+		fmt.Fprintln(conn, l)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	var got []string
+	input := bufio.NewScanner(conn)
+	for input.Scan() {
+		got = append(got, input.Text())
+	}
+	if err := input.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestServer_OrderedModeEchoesLinesOneByOne(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &echo.Server{
+		Delay:      5 * time.Millisecond,
+		Transforms: []echo.Transform{echo.Upper, echo.Lower},
+		Mode:       echo.Ordered,
+	})
+	want := []string{
+		"\t ONE", "\t one", "\t --- --- ---",
+		"\t TWO", "\t two", "\t --- --- ---",
+		"\t THREE", "\t three", "\t --- --- ---",
+	}
+	got := shoutAll(t, addr, "one", "two", "three")
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_OrderedModeEchoesLinesOfDatagramOneByOne(t *testing.T) {
+	t.Parallel()
+
+	conn := listenAndDial(t, &echo.Server{
+		Delay:      5 * time.Millisecond,
+		Transforms: []echo.Transform{echo.Upper, echo.Lower},
+		Mode:       echo.Ordered,
+	}, "udp")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := fmt.Fprint(conn, "one\ntwo\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"\t ONE", "\t one", "\t --- --- ---",
+		"\t TWO", "\t two", "\t --- --- ---",
+	}
+	var got []string
+	input := bufio.NewScanner(conn)
+	for len(got) < len(want) && input.Scan() {
+		got = append(got, input.Text())
+	}
+	if err := input.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_InterleavedModeWithSingleSlotEchoesInOrder(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &echo.Server{
+		Delay:       5 * time.Millisecond,
+		Transforms:  []echo.Transform{echo.Upper},
+		MaxInFlight: 1,
+	})
+	want := []string{"\t ONE", "\t --- --- ---", "\t TWO", "\t --- --- ---"}
+	got := shoutAll(t, addr, "one", "two")
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_InterleavedModeFinishesPendingEchoesBeforeClosing(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &echo.Server{
+		Delay:      5 * time.Millisecond,
+		Transforms: []echo.Transform{echo.Upper, echo.Lower},
+	})
+	lines := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	got := shoutAll(t, addr, lines...)
+
+	want := make(map[string]int)
+	for _, l := range lines {
+		want["\t "+echo.Upper(l)]++
+		want["\t "+l]++
+		want["\t --- --- ---"]++
+	}
+	counts := make(map[string]int)
+	for _, l := range got {
+		counts[l]++
+	}
+	if !cmp.Equal(want, counts) {
+		t.Error(cmp.Diff(want, counts))
+	}
+}
+
+// listenAndDial starts the server on the network and returns
+// a client connection to it.
+func listenAndDial(t *testing.T, s *echo.Server, network string) net.Conn {
+	t.Helper()
+	dir := t.TempDir()
+	var conn net.Conn
+	switch network {
+	case "tcp", "unix":
+		addr := "127.0.0.1:0"
+		if network == "unix" {
+			addr = filepath.Join(dir, "echo.sock")
+		}
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go s.Serve(l)
+		conn, err = net.Dial(network, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "udp", "unixgram":
+		addr := "127.0.0.1:0"
+		if network == "unixgram" {
+			addr = filepath.Join(dir, "echo.sock")
+		}
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pc.Close() })
+		go s.ServePacket(pc)
+		if network == "unixgram" {
+			// datagram unix clients need their own address to get replies
+			laddr := &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: network}
+			conn, err = net.DialUnix(network, laddr, pc.LocalAddr().(*net.UnixAddr))
+		} else {
+			conn, err = net.Dial(network, pc.LocalAddr().String())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServer_EchoesOverAllTransports(t *testing.T) {
+	t.Parallel()
+
+	for _, network := range []string{"tcp", "unix", "udp", "unixgram"} {
+		network := network
+		t.Run(network, func(t *testing.T) {
+			t.Parallel()
+
+			conn := listenAndDial(t, &echo.Server{Mode: echo.Ordered}, network)
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := fmt.Fprintln(conn, "hello"); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{"\t HELLO", "\t Hello", "\t hello", "\t --- --- ---"}
+			var got []string
+			input := bufio.NewScanner(conn)
+			for len(got) < len(want) && input.Scan() {
+				got = append(got, input.Text())
+			}
+			if err := input.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(want, got) {
+				t.Error(cmp.Diff(want, got))
+			}
+		})
+	}
+}
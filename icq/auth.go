@@ -0,0 +1,105 @@
+package icq
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrUnauthorized is returned by authenticators when
+// the credentials presented by a client are invalid.
+var ErrUnauthorized = errors.New("icq: invalid credentials")
+
+// Authenticator verifies credentials presented by a client
+// with the "/auth USER SECRET" command before the client
+// is allowed to join the chat.
+type Authenticator interface {
+	Authenticate(user, secret string) error
+}
+
+// Passwords is a static set of user passwords.
+type Passwords map[string]string
+
+// Authenticate checks the secret against the user's password.
+func (p Passwords) Authenticate(user, secret string) error {
+	want, ok := p[user]
+	if !ok || subtle.ConstantTimeCompare([]byte(want), []byte(secret)) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// LoadPasswordFile reads passwords from a file holding
+// one "user:password" entry per line. Empty lines and lines
+// starting with # are ignored.
+func LoadPasswordFile(path string) (Passwords, error) {
+	p := make(Passwords)
+	err := readEntries(path, func(line string) error {
+		user, password, ok := strings.Cut(line, ":")
+		if !ok || !validNick(user) {
+			return fmt.Errorf("invalid entry %q, want user:password", line)
+		}
+		p[user] = password
+		return nil
+	})
+	return p, err
+}
+
+// Tokens maps access tokens to the users they were issued to.
+// A token lets in only its own user.
+type Tokens map[string]string
+
+// Authenticate checks if the secret is a token issued to the user.
+func (t Tokens) Authenticate(user, secret string) error {
+	ok := false
+	for token, owner := range t {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 && owner == user {
+			ok = true
+		}
+	}
+	if !ok {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// LoadTokenFile reads tokens from a file holding one "user:token"
+// entry per line. Empty lines and lines starting with # are ignored.
+func LoadTokenFile(path string) (Tokens, error) {
+	t := make(Tokens)
+	err := readEntries(path, func(line string) error {
+		user, token, ok := strings.Cut(line, ":")
+		if !ok || !validNick(user) || token == "" {
+			return fmt.Errorf("invalid entry %q, want user:token", line)
+		}
+		if _, dup := t[token]; dup {
+			return fmt.Errorf("token of %s is already issued to %s", user, t[token])
+		}
+		t[token] = user
+		return nil
+	})
+	return t, err
+}
+
+func readEntries(path string, parse func(string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	input := bufio.NewScanner(f)
+	for n := 1; input.Scan(); n++ {
+		line := strings.TrimSpace(input.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	return input.Err()
+}
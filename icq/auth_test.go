@@ -0,0 +1,154 @@
+package icq_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/icq"
+)
+
+func TestServer_AuthenticatesClientsBeforeTheyJoin(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{Auth: icq.Passwords{"alice": "s3cret"}})
+	c := dial(t, addr)
+	c.expectPrefix("* authentication required")
+
+	c.send("hello")
+	c.expectPrefix("* usage: /auth USER SECRET")
+	c.send("/auth alice wrong")
+	c.expectPrefix("* authentication failed")
+	c.send("/auth alice s3cret")
+	c.expectPrefix("* authenticated as alice")
+
+	c.send("hi")
+	c.expectPrefix("alice: hi")
+}
+
+func TestServer_DisconnectsClientAfterFailedAuthAttempts(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{Auth: icq.Tokens{"t0ken": "alice"}})
+	c := dial(t, addr)
+	c.expectPrefix("* authentication required")
+	for i := 0; i < 3; i++ {
+		c.send("/auth alice bad")
+		c.expectPrefix("* authentication failed")
+	}
+	if c.input.Scan() {
+		t.Errorf("want connection closed, got %q", c.input.Text())
+	}
+}
+
+func TestTokens_LetInOnlyTheirUsers(t *testing.T) {
+	t.Parallel()
+
+	tokens := icq.Tokens{"t0ken": "alice", "op-t0ken": "op"}
+	if err := tokens.Authenticate("alice", "t0ken"); err != nil {
+		t.Errorf("want alice let in with her token, got %v", err)
+	}
+	for _, user := range []string{"op", "bob"} {
+		if err := tokens.Authenticate(user, "t0ken"); !errors.Is(err, icq.ErrUnauthorized) {
+			t.Errorf("want %s refused with alice's token, got %v", user, err)
+		}
+	}
+}
+
+func TestLoadTokenFile_ReadsUserTokenEntries(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tokens")
+	data := "# tokens\nalice:t0ken\n\nop:op-t0ken\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	want := icq.Tokens{"t0ken": "alice", "op-t0ken": "op"}
+	got, err := icq.LoadTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_RefusesSecondSessionOfUser(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{Auth: icq.Passwords{"alice": "s3cret"}})
+	first := dial(t, addr)
+	first.expectPrefix("* authentication required")
+	first.send("/auth alice s3cret")
+	first.expectPrefix("* authenticated as alice")
+	first.send("/who")
+	first.expectPrefix("* in lobby: alice")
+
+	second := dial(t, addr)
+	second.expectPrefix("* authentication required")
+	second.send("/auth alice s3cret")
+	second.expectPrefix("* authenticated as alice")
+	second.expectPrefix("* alice is already connected")
+	second.expectClosed()
+}
+
+func TestLoadPasswordFile_ReadsUserPasswordEntries(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "passwords")
+	data := "# users\nalice:s3cret\n\nbob:pass:word\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	want := icq.Passwords{"alice": "s3cret", "bob": "pass:word"}
+	got, err := icq.LoadPasswordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestClient_ConnectsToTLSServerWithSelfSignedCert(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := icq.GenerateCert(certFile, keyFile, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	serverCfg, err := icq.LoadTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := icq.LoadCertPool(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &icq.Server{Auth: icq.Tokens{"t0ken": "alice"}, Logger: log.New(io.Discard, "", 0)}
+	go s.Serve(tls.NewListener(l, serverCfg))
+
+	_, output := runClient(t, &icq.Client{
+		Addr:      l.Addr().String(),
+		Nick:      "alice",
+		Secret:    "t0ken",
+		TLSConfig: &tls.Config{RootCAs: pool},
+	})
+	got := readLines(t, output, 3)
+	if got[2] != "* authenticated as alice" {
+		t.Errorf("want authentication confirmed, got %q", got)
+	}
+}
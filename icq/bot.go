@@ -0,0 +1,69 @@
+package icq
+
+import "time"
+
+// Bot is an automated chat participant attached to the server.
+//
+// The server calls Run in a separate goroutine when it starts.
+// Run receives every message posted in the bot's rooms, except
+// messages of bots, this server's or linked servers', from the
+// messages channel and posts to rooms with the post func.
+type Bot interface {
+	// Name is the nick the bot posts as.
+	Name() string
+
+	// Rooms the bot is subscribed to.
+	Rooms() []string
+
+	Run(messages <-chan Message, post func(room, text string))
+}
+
+// botBuffer is a number of messages queued for a bot. Messages
+// are dropped when a bot falls behind, so a slow bot can't block
+// the whole chat.
+const botBuffer = 64
+
+// botClient connects a bot to the messanger.
+type botClient struct {
+	name  string
+	rooms map[string]bool
+	in    chan Message
+}
+
+// deliver queues the message for the bot if the bot is
+// subscribed to the message's room.
+func (b *botClient) deliver(m Message) {
+	if !b.rooms[m.Room] || m.From == b.name || m.Kind == KindBot || m.Kind == KindTyping || m.Kind == KindPing {
+		return
+	}
+	select {
+	case b.in <- m:
+	default:
+	}
+}
+
+// isBot reports whether the nick is used by a bot.
+func (h *hub) isBot(nick string) bool {
+	for _, b := range h.bots {
+		if b.name == nick {
+			return true
+		}
+	}
+	return false
+}
+
+// startBots attaches the server's bots to the hub and runs them.
+func (s *Server) startBots(h *hub) {
+	for _, bot := range s.Bots {
+		b := &botClient{name: bot.Name(), rooms: make(map[string]bool), in: make(chan Message, botBuffer)}
+		for _, r := range bot.Rooms() {
+			b.rooms[r] = true
+		}
+		h.bots = append(h.bots, b)
+
+		post := func(room, text string) {
+			s.botPosts <- Message{Time: time.Now(), Kind: KindBot, Room: room, From: b.name, Text: text}
+		}
+		go bot.Run(b.in, post)
+	}
+}
@@ -0,0 +1,146 @@
+package icq
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// EchoBot repeats every chat message posted in its rooms.
+type EchoBot struct {
+	Nick     string
+	RoomList []string
+}
+
+// NewEchoBot returns a bot echoing messages in the rooms.
+func NewEchoBot(name string, rooms ...string) *EchoBot {
+	return &EchoBot{Nick: name, RoomList: rooms}
+}
+
+func (b *EchoBot) Name() string    { return b.Nick }
+func (b *EchoBot) Rooms() []string { return b.RoomList }
+
+func (b *EchoBot) Run(messages <-chan Message, post func(room, text string)) {
+	for m := range messages {
+		if m.From == "" || m.Kind != "" {
+			continue
+		}
+		post(m.Room, m.From+" said: "+m.Text)
+	}
+}
+
+// ClockBot announces the current time in its rooms at a regular interval.
+type ClockBot struct {
+	Nick     string
+	RoomList []string
+
+	// Interval between announcements, one hour if zero.
+	Interval time.Duration
+
+	// Layout of the announced time, "15:04" if empty.
+	Layout string
+}
+
+// NewClockBot returns a bot announcing time in the rooms every interval.
+func NewClockBot(name string, interval time.Duration, rooms ...string) *ClockBot {
+	return &ClockBot{Nick: name, RoomList: rooms, Interval: interval}
+}
+
+func (b *ClockBot) Name() string    { return b.Nick }
+func (b *ClockBot) Rooms() []string { return b.RoomList }
+
+func (b *ClockBot) Run(messages <-chan Message, post func(room, text string)) {
+	interval, layout := b.Interval, b.Layout
+	if interval == 0 {
+		interval = time.Hour
+	}
+	if layout == "" {
+		layout = "15:04"
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-messages:
+			// the clock doesn't talk, drain the messages
+			if !ok {
+				return
+			}
+		case now := <-ticker.C:
+			for _, r := range b.RoomList {
+				post(r, "it's "+now.Format(layout))
+			}
+		}
+	}
+}
+
+// CommandFunc handles a bot command. It receives the command arguments
+// and the message carrying the command, and returns the reply.
+// An empty reply is not posted.
+type CommandFunc func(args []string, m Message) string
+
+// CommandBot dispatches commands, chat messages starting with
+// Prefix followed by a command name, to registered handlers.
+// It always knows the "help" command listing all commands.
+type CommandBot struct {
+	Nick     string
+	RoomList []string
+
+	// Prefix marking commands for the bot, "!" if empty.
+	Prefix string
+
+	commands map[string]CommandFunc
+}
+
+// NewCommandBot returns a command dispatcher bot serving the rooms.
+func NewCommandBot(name string, rooms ...string) *CommandBot {
+	return &CommandBot{Nick: name, RoomList: rooms, commands: make(map[string]CommandFunc)}
+}
+
+// Handle registers the handler for the command name.
+// It must be called before the server starts.
+func (b *CommandBot) Handle(name string, fn CommandFunc) {
+	if b.commands == nil {
+		b.commands = make(map[string]CommandFunc)
+	}
+	b.commands[name] = fn
+}
+
+func (b *CommandBot) Name() string    { return b.Nick }
+func (b *CommandBot) Rooms() []string { return b.RoomList }
+
+func (b *CommandBot) Run(messages <-chan Message, post func(room, text string)) {
+	prefix := b.Prefix
+	if prefix == "" {
+		prefix = "!"
+	}
+	for m := range messages {
+		line, ok := strings.CutPrefix(m.Text, prefix)
+		if m.From == "" || m.Kind != "" || !ok {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if reply := b.dispatch(prefix, fields[0], fields[1:], m); reply != "" {
+			post(m.Room, reply)
+		}
+	}
+}
+
+func (b *CommandBot) dispatch(prefix, name string, args []string, m Message) string {
+	if name == "help" {
+		names := []string{prefix + "help"}
+		for n := range b.commands {
+			names = append(names, prefix+n)
+		}
+		sort.Strings(names)
+		return "commands: " + strings.Join(names, ", ")
+	}
+	fn, ok := b.commands[name]
+	if !ok {
+		return "unknown command " + prefix + name + ", try " + prefix + "help"
+	}
+	return fn(args, m)
+}
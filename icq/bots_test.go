@@ -0,0 +1,110 @@
+package icq_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/icq"
+)
+
+func TestEchoBot_RepliesToChatMessages(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{Bots: []icq.Bot{icq.NewEchoBot("parrot", icq.DefaultRoom)}})
+	c := dial(t, addr)
+	c.nick("alice")
+	c.send("hello")
+	c.expectPrefix("alice: hello")
+	c.expectPrefix("parrot: alice said: hello")
+}
+
+func TestEchoBots_DoNotEchoEachOther(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{Bots: []icq.Bot{
+		icq.NewEchoBot("parrot", icq.DefaultRoom),
+		icq.NewEchoBot("myna", icq.DefaultRoom),
+	}})
+	c := dial(t, addr)
+	c.nick("alice")
+	c.send("hello")
+	c.expectPrefix("alice: hello")
+	echoes := []string{c.read(), c.read()}
+	sort.Strings(echoes)
+	if want := []string{"myna: alice said: hello", "parrot: alice said: hello"}; !cmp.Equal(want, echoes) {
+		t.Error(cmp.Diff(want, echoes))
+	}
+	c.send("/who")
+	c.expectPrefix("* in lobby: ")
+}
+
+func TestFederation_BotsIgnoreRelayedBotMessages(t *testing.T) {
+	t.Parallel()
+
+	addrA, linkA := startLinkedServer(t, &icq.Server{Name: "A", LinkSecret: linkSecret,
+		Bots: []icq.Bot{icq.NewEchoBot("echo", icq.DefaultRoom)}})
+	addrB := startServer(t, &icq.Server{Name: "B", LinkSecret: linkSecret, Peers: []string{linkA},
+		Bots: []icq.Bot{icq.NewEchoBot("echo", icq.DefaultRoom)}})
+
+	bob := dial(t, addrA)
+	bob.nick("bob")
+	c := dial(t, addrB)
+	c.nick("alice")
+	c.waitFor("bob@A")
+	c.send("hello")
+	want := map[string]bool{"alice: hello": true, "echo: alice said: hello": true, "echo@A: alice@B said: hello": true}
+	for len(want) > 0 {
+		got := c.nextChat()
+		if !want[got] {
+			t.Fatalf("want one echo from each server, got %q", got)
+		}
+		delete(want, got)
+	}
+	c.send("hi")
+	if got := c.nextChat(); got != "alice: hi" {
+		t.Errorf("want bots quiet, got %q", got)
+	}
+}
+
+func TestCommandBot_DispatchesCommands(t *testing.T) {
+	t.Parallel()
+
+	bot := icq.NewCommandBot("butler", icq.DefaultRoom)
+	bot.Handle("upper", func(args []string, m icq.Message) string {
+		return strings.ToUpper(strings.Join(args, " "))
+	})
+	addr := startServer(t, &icq.Server{Bots: []icq.Bot{bot}})
+	c := dial(t, addr)
+	c.nick("alice")
+
+	c.send("!upper make it loud")
+	c.skipUntil("butler: MAKE IT LOUD")
+	c.send("!help")
+	c.skipUntil("butler: commands: !help, !upper")
+	c.send("!dance")
+	c.skipUntil("butler: unknown command !dance, try !help")
+}
+
+func TestClockBot_AnnouncesTime(t *testing.T) {
+	t.Parallel()
+
+	bot := icq.NewClockBot("clock", 10*time.Millisecond, icq.DefaultRoom)
+	addr := startServer(t, &icq.Server{Bots: []icq.Bot{bot}})
+	c := dial(t, addr)
+	c.expectPrefix("clock: it's ")
+}
+
+func TestServer_ListsBotsAndReservesTheirNicks(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{Bots: []icq.Bot{icq.NewEchoBot("parrot", icq.DefaultRoom)}})
+	c := dial(t, addr)
+	c.send("/nick parrot")
+	c.expectPrefix("* nick parrot is taken")
+	c.nick("alice")
+	c.send("/who")
+	c.expectPrefix("* in lobby: alice, parrot")
+}
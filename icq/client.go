@@ -0,0 +1,234 @@
+package icq
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Client is an ICQ terminal client. It connects to the server,
+// sets up the nickname and renders received messages. When the
+// connection drops the client reconnects with exponential backoff.
+type Client struct {
+	// Addr is the server address, "localhost:8000" if empty.
+	Addr string
+
+	// Nick is the nickname announced to the server after connecting.
+	Nick string
+
+	// Secret, if set, is a password or token the client
+	// authenticates with as Nick instead of announcing the nick.
+	Secret string
+
+	// TLSConfig, if set, makes the client connect over TLS.
+	TLSConfig *tls.Config
+
+	// Output is where messages are rendered to.
+	Output io.Writer
+
+	// Timestamps prefixes rendered messages with the time
+	// they were received.
+	Timestamps bool
+
+	// MinBackoff and MaxBackoff bound the delay between
+	// reconnection attempts. Defaults are 100ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// self is the name the server knows the client by and sent
+	// holds own lines waiting to be echoed back by the server.
+	self string
+	sent []string
+}
+
+// Run reads lines from the input and sends them to the server until
+// the input is exhausted or the context is cancelled. Messages from
+// the server are rendered to the client's Output.
+func (c *Client) Run(ctx context.Context, input io.Reader) error {
+	if c.Addr == "" {
+		c.Addr = "localhost:8000"
+	}
+	if c.MinBackoff == 0 {
+		c.MinBackoff = 100 * time.Millisecond
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		in := bufio.NewScanner(input)
+		for in.Scan() {
+			select {
+			case lines <- in.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	backoff := c.MinBackoff
+	for {
+		conn, err := c.dial(ctx)
+		if err == nil {
+			backoff = c.MinBackoff
+			done, err := c.session(ctx, conn, lines)
+			if done {
+				return nil
+			}
+			c.system(fmt.Sprintf("connection lost: %v", err))
+		} else {
+			c.system(fmt.Sprintf("connecting to %s: %v", c.Addr, err))
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		c.system(fmt.Sprintf("reconnecting in %v", backoff))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	if c.TLSConfig != nil {
+		d := tls.Dialer{Config: c.TLSConfig}
+		return d.DialContext(ctx, "tcp", c.Addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", c.Addr)
+}
+
+// session talks to the server over a single connection. It reports
+// done when the input is exhausted or the context is cancelled, and
+// the error that broke the connection otherwise.
+func (c *Client) session(ctx context.Context, conn net.Conn, lines <-chan string) (done bool, err error) {
+	defer conn.Close()
+	c.self = conn.LocalAddr().String()
+	c.sent = nil
+
+	quit := make(chan struct{})
+	defer close(quit)
+
+	incoming := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		in := bufio.NewScanner(conn)
+		for in.Scan() {
+			select {
+			case incoming <- in.Text():
+			case <-quit:
+				return
+			}
+		}
+		err := in.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		readErr <- err
+	}()
+
+	// nick asked for, the client's own once the server confirms it
+	var nick string
+	if c.Nick != "" {
+		setup := "/nick " + c.Nick
+		if c.Secret != "" {
+			setup = "/auth " + c.Nick + " " + c.Secret
+		}
+		if _, err := fmt.Fprintln(conn, setup); err != nil {
+			return false, err
+		}
+		nick = c.Nick
+	}
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return true, nil
+			}
+			if _, err := fmt.Fprintln(conn, line); err != nil {
+				return false, err
+			}
+			if name, ok := strings.CutPrefix(line, "/nick "); ok {
+				nick = strings.TrimSpace(name)
+			}
+			if !strings.HasPrefix(line, "/") {
+				c.sent = append(c.sent, line)
+			}
+		case line := <-incoming:
+			if line == "/ping" {
+				if _, err := fmt.Fprintln(conn, "/pong"); err != nil {
+					return false, err
+				}
+				continue
+			}
+			if c.confirmNick(line, nick) {
+				nick = ""
+			}
+			c.render(line)
+		case err := <-readErr:
+			return false, err
+		case <-ctx.Done():
+			return true, nil
+		}
+	}
+}
+
+// confirmNick makes the nick the client asked for its own if the
+// line is the server's confirmation of it, and reports whether it was.
+func (c *Client) confirmNick(line, nick string) bool {
+	text, ok := strings.CutPrefix(line, "* ")
+	if !ok || nick == "" {
+		return false
+	}
+	if text != "authenticated as "+nick && text != c.self+" is now known as "+nick {
+		return false
+	}
+	c.self = nick
+	return true
+}
+
+// render prints a line received from the server. Own chat messages
+// echoed back by the server are suppressed as the user has already
+// seen them while typing.
+func (c *Client) render(line string) {
+	if text, ok := strings.CutPrefix(line, "* "); ok {
+		c.system(text)
+		return
+	}
+	from, text, ok := strings.Cut(line, ": ")
+	if !ok {
+		c.print(line)
+		return
+	}
+	if from == c.self && len(c.sent) > 0 && c.sent[0] == text {
+		c.sent = c.sent[1:]
+		return
+	}
+	c.print("<" + from + "> " + text)
+}
+
+func (c *Client) system(text string) {
+	c.print("* " + text)
+}
+
+func (c *Client) print(s string) {
+	if c.Timestamps {
+		s = time.Now().Format("15:04:05") + " " + s
+	}
+	fmt.Fprintln(c.Output, s)
+}
@@ -0,0 +1,144 @@
+package icq_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/icq"
+)
+
+// runClient runs the client in the background. Lines rendered
+// by the client are delivered to the returned channel.
+func runClient(t *testing.T, c *icq.Client) (input *io.PipeWriter, output <-chan string) {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c.Output = outW
+
+	lines := make(chan string, 100)
+	go func() {
+		out := bufio.NewScanner(outR)
+		for out.Scan() {
+			lines <- out.Text()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx, inR)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		outR.Close()
+		<-done
+	})
+	return inW, lines
+}
+
+func readLines(t *testing.T, output <-chan string, n int) []string {
+	t.Helper()
+	var got []string
+	for i := 0; i < n; i++ {
+		select {
+		case l := <-output:
+			got = append(got, l)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out after %d lines: %q", len(got), got)
+		}
+	}
+	return got
+}
+
+func TestClient_RendersMessagesAndSuppressesOwnEcho(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{})
+	input, output := runClient(t, &icq.Client{Addr: addr, Nick: "alice"})
+	greeting := readLines(t, output, 2)
+	if !strings.HasSuffix(greeting[1], " is now known as alice") {
+		t.Fatalf("unexpected nick confirmation %q", greeting[1])
+	}
+
+	bob := dial(t, addr)
+	io.WriteString(input, "hi\n")
+	bob.expectPrefix("alice: hi")
+	bob.send("yo")
+
+	who := bob.conn.LocalAddr().String()
+	want := []string{"* " + who + " has joined conversation", "<" + who + "> yo"}
+	got := readLines(t, output, 2)
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestClient_ReconnectsWhenConnectionDrops(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	runClient(t, &icq.Client{
+		Addr:       l.Addr().String(),
+		Nick:       "alice",
+		MinBackoff: time.Millisecond,
+	})
+
+	for i := 0; i < 2; i++ {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "/nick alice\n" {
+			t.Errorf("connection %d: want nick setup, got %q", i, line)
+		}
+		conn.Close()
+	}
+}
+
+func TestClient_TakesNickOnlyOnceServerConfirmsIt(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		in := bufio.NewScanner(conn)
+		in.Scan() // the nick setup
+		io.WriteString(conn, "* nick alice is taken\n")
+		in.Scan()
+		// the other alice says the same
+		io.WriteString(conn, "alice: "+in.Text()+"\n")
+		in.Scan()
+	}()
+
+	input, output := runClient(t, &icq.Client{Addr: l.Addr().String(), Nick: "alice"})
+	readLines(t, output, 1)
+	io.WriteString(input, "hi\n")
+	if want, got := "<alice> hi", readLines(t, output, 1)[0]; want != got {
+		t.Errorf("want the other alice's message shown, got %q", got)
+	}
+}
@@ -0,0 +1,479 @@
+package icq
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// Federation lets several ICQ servers share rooms. Servers link
+// over TCP and relay chat messages and presence (users joining and
+// leaving rooms) to each other. Every relay carries a unique ID and
+// the name of the server it originated from; a server drops relays
+// it has already seen, so messages don't loop when links form a cycle.
+//
+// Links are meant to form a tree. Cycles are tolerated, but presence
+// of users behind a dropped link is forgotten until the link is back.
+//
+// Linked servers trust each other's relays, so a link starts with
+// a handshake proving both ends know the shared LinkSecret: each
+// server sends a random nonce and answers the peer's nonce with an
+// HMAC of it keyed with the secret. The secret itself never crosses
+// the link. With LinkTLSConfig links are also encrypted.
+
+const (
+	relayHello    = "hello"
+	relayAuth     = "auth"
+	relayMessage  = "message"
+	relayJoin     = "join"
+	relayLeave    = "leave"
+	relayPresence = "presence"
+	relayTyping   = "typing"
+
+	// linkBuffer is a number of relays queued for a slow link
+	// before new relays are dropped.
+	linkBuffer = 256
+
+	// seenSize is a number of recent relay IDs remembered
+	// for loop prevention.
+	seenSize = 4096
+
+	// linkHandshakeTimeout is how long a peer has
+	// to complete the link handshake.
+	linkHandshakeTimeout = 10 * time.Second
+)
+
+// errNoLinkSecret is returned when linking without a LinkSecret.
+var errNoLinkSecret = errors.New("icq: links require a LinkSecret")
+
+// relay is a unit of data exchanged between linked servers.
+type relay struct {
+	ID      string  `json:"id,omitempty"`
+	Kind    string  `json:"kind"`
+	Origin  string  `json:"origin"`
+	Message Message `json:"message"`
+}
+
+// handshake is exchanged by servers starting a link,
+// a hello with a nonce followed by the proof of the secret.
+type handshake struct {
+	Kind   string `json:"kind"`
+	Origin string `json:"origin"`
+	Nonce  string `json:"nonce,omitempty"`
+	Proof  string `json:"proof,omitempty"`
+}
+
+// link is a connection to another server.
+type link struct {
+	peer string // name of the server on the other end
+	out  chan relay
+}
+
+// incoming is a relay received over the link.
+type incoming struct {
+	l *link
+	r relay
+}
+
+// seen remembers a limited number of recently seen relay IDs.
+type seen struct {
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newSeen(size int) *seen {
+	return &seen{ids: make(map[string]bool), order: make([]string, size)}
+}
+
+// add records the ID. It reports false if the ID was already seen.
+func (s *seen) add(id string) bool {
+	if s.ids[id] {
+		return false
+	}
+	delete(s.ids, s.order[s.next])
+	s.order[s.next] = id
+	s.next = (s.next + 1) % len(s.order)
+	s.ids[id] = true
+	return true
+}
+
+// remoteUser is a user connected to another server.
+type remoteUser struct {
+	nick   string
+	origin string
+}
+
+func (u remoteUser) String() string {
+	return u.nick + "@" + u.origin
+}
+
+// federation holds the federation state owned by the messanger goroutine.
+type federation struct {
+	links  map[*link]bool
+	seen   *seen
+	remote map[remoteUser]string // room each remote user is in
+	status map[remoteUser]string // presence state of remote users
+	via    map[string]*link      // link each origin server is reachable through
+	seq    int
+}
+
+func newFederation() *federation {
+	return &federation{
+		links:  make(map[*link]bool),
+		seen:   newSeen(seenSize),
+		remote: make(map[remoteUser]string),
+		status: make(map[remoteUser]string),
+		via:    make(map[string]*link),
+	}
+}
+
+// relay sends a local event to all linked servers.
+func (s *Server) relay(h *hub, kind string, m Message) {
+	h.fed.seq++
+	r := relay{
+		ID:      fmt.Sprintf("%s/%d", s.instance, h.fed.seq),
+		Kind:    kind,
+		Origin:  s.Name,
+		Message: m,
+	}
+	h.fed.seen.add(r.ID)
+	s.forward(h, r, nil)
+}
+
+// forward sends the relay to all links except the one it came from.
+func (s *Server) forward(h *hub, r relay, from *link) {
+	for l := range h.fed.links {
+		if l == from {
+			continue
+		}
+		select {
+		case l.out <- r:
+		default:
+			s.Logger.Printf("link to %s is congested, dropping relay %s", l.peer, r.ID)
+		}
+	}
+}
+
+// receive handles a relay that arrived over a link.
+func (s *Server) receive(h *hub, in incoming) {
+	r := in.r
+	if r.Origin == s.Name || !h.fed.seen.add(r.ID) {
+		return
+	}
+	if _, ok := h.fed.via[r.Origin]; !ok {
+		h.fed.via[r.Origin] = in.l
+	}
+	s.forward(h, r, in.l)
+
+	u := remoteUser{nick: r.Message.From, origin: r.Origin}
+	switch r.Kind {
+	case relayMessage:
+		m := r.Message
+		if m.From != "" {
+			m.From = u.String()
+		}
+		s.publish(h, m)
+	case relayJoin:
+		if room, ok := h.fed.remote[u]; ok && room == r.Message.Room {
+			return
+		}
+		h.fed.remote[u] = r.Message.Room
+		h.broadcast(s.notice(r.Message.Room, u.String()+" has joined conversation"))
+	case relayLeave:
+		if room, ok := h.fed.remote[u]; !ok || room != r.Message.Room {
+			return
+		}
+		delete(h.fed.remote, u)
+		delete(h.fed.status, u)
+		h.broadcast(s.notice(r.Message.Room, u.String()+" has left"))
+	case relayPresence, relayTyping:
+		if r.Kind == relayPresence {
+			h.fed.status[u] = r.Message.Text
+		}
+		m := r.Message
+		m.From = u.String()
+		h.broadcast(m)
+	}
+}
+
+// linkUp registers the link and tells the peer about all users
+// this server knows about, so both sides share presence.
+func (s *Server) linkUp(h *hub, l *link) {
+	h.fed.links[l] = true
+	s.Logger.Printf("linked with %s", l.peer)
+
+	send := func(kind, origin string, m Message) {
+		h.fed.seq++
+		r := relay{
+			ID:      fmt.Sprintf("%s/%d", s.instance, h.fed.seq),
+			Kind:    kind,
+			Origin:  origin,
+			Message: m,
+		}
+		h.fed.seen.add(r.ID)
+		select {
+		case l.out <- r:
+		default:
+		}
+	}
+	sync := func(origin, nick, room, status string) {
+		now := time.Now()
+		send(relayJoin, origin, Message{Time: now, Room: room, From: nick})
+		if status != "" && status != Online {
+			send(relayPresence, origin, Message{Time: now, Kind: KindPresence, Room: room, From: nick, Text: status})
+		}
+	}
+	for cl := range h.clients {
+		sync(s.Name, cl.who, cl.room, cl.status)
+	}
+	for u, room := range h.fed.remote {
+		if h.fed.via[u.origin] != l {
+			sync(u.origin, u.nick, room, h.fed.status[u])
+		}
+	}
+}
+
+// linkDown removes the link and forgets users behind it.
+func (s *Server) linkDown(h *hub, l *link) {
+	delete(h.fed.links, l)
+	close(l.out)
+	s.Logger.Printf("link with %s is down", l.peer)
+
+	for origin, via := range h.fed.via {
+		if via != l {
+			continue
+		}
+		delete(h.fed.via, origin)
+		for u, room := range h.fed.remote {
+			if u.origin == origin {
+				delete(h.fed.remote, u)
+				delete(h.fed.status, u)
+				h.broadcast(s.notice(room, u.String()+" has left (link lost)"))
+			}
+		}
+	}
+}
+
+// who returns names of local and remote users in the room.
+func (h *hub) who(room string) []string {
+	var names []string
+	withStatus := func(name, status string) string {
+		if status == "" || status == Online {
+			return name
+		}
+		return name + " (" + status + ")"
+	}
+	for cl := range h.clients {
+		if cl.room == room {
+			names = append(names, withStatus(cl.who, cl.status))
+		}
+	}
+	for u, r := range h.fed.remote {
+		if r == room {
+			names = append(names, withStatus(u.String(), h.fed.status[u]))
+		}
+	}
+	for _, b := range h.bots {
+		if b.rooms[room] {
+			names = append(names, b.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServeLinks accepts links from other servers on the listener l.
+func (s *Server) ServeLinks(l net.Listener) error {
+	if err := s.setup(); err != nil {
+		return err
+	}
+	if s.LinkSecret == "" {
+		return errNoLinkSecret
+	}
+	s.Logger.Printf("accepting links on " + l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.Logger.Print(err)
+				continue
+			}
+			return err
+		}
+		if s.LinkTLSConfig != nil {
+			conn = tls.Server(conn, s.LinkTLSConfig)
+		}
+		go func() {
+			if err := s.runLink(conn, false); err != nil {
+				s.Logger.Print(err)
+			}
+		}()
+	}
+}
+
+// dialPeer keeps the link to the peer server up, reconnecting
+// with exponential backoff when the link drops.
+func (s *Server) dialPeer(addr string) {
+	const (
+		minBackoff = 100 * time.Millisecond
+		maxBackoff = 30 * time.Second
+	)
+	backoff := minBackoff
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			backoff = minBackoff
+			if s.LinkTLSConfig != nil {
+				cfg := s.LinkTLSConfig.Clone()
+				if cfg.ServerName == "" {
+					cfg.ServerName = hostOf(addr)
+				}
+				conn = tls.Client(conn, cfg)
+			}
+			err = s.runLink(conn, true)
+		}
+		s.Logger.Printf("link to %s: %v, reconnecting in %v", addr, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runLink authenticates the peer and then pumps relays
+// in both directions until the connection breaks. Dialed
+// tells whether this server dialed the peer or accepted it.
+func (s *Server) runLink(conn net.Conn, dialed bool) error {
+	defer conn.Close()
+	if s.LinkSecret == "" {
+		return errNoLinkSecret
+	}
+
+	enc := json.NewEncoder(conn)
+	input := bufio.NewScanner(conn)
+	input.Buffer(nil, wsMaxMessage)
+	conn.SetDeadline(time.Now().Add(linkHandshakeTimeout))
+	peer, err := s.linkHandshake(enc, input, dialed)
+	if err != nil {
+		return fmt.Errorf("link handshake with %s: %w", conn.RemoteAddr(), err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	l := &link{peer: peer, out: make(chan relay, linkBuffer)}
+	s.linksUp <- l
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for r := range l.out {
+			if err := enc.Encode(r); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	for input.Scan() {
+		var r relay
+		if err := json.Unmarshal(input.Bytes(), &r); err != nil {
+			s.Logger.Printf("link with %s: %v", l.peer, err)
+			continue
+		}
+		s.relays <- incoming{l: l, r: r}
+	}
+	s.linksDown <- l
+	<-written
+	return fmt.Errorf("link with %s closed: %v", l.peer, input.Err())
+}
+
+// linkHandshake proves to the peer that this server knows the link
+// secret and checks that the peer knows it too. It returns the name
+// of the peer.
+//
+// Each proof covers both nonces, the role of the server proving and
+// the names of both servers, so a proof one server gives can't be
+// passed on to another server or back to the same one.
+func (s *Server) linkHandshake(enc *json.Encoder, input *bufio.Scanner, dialed bool) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	hello := handshake{Kind: relayHello, Origin: s.Name, Nonce: hex.EncodeToString(nonce)}
+	if err := enc.Encode(hello); err != nil {
+		return "", err
+	}
+	peer, err := readHandshake(input, relayHello)
+	if err != nil {
+		return "", err
+	}
+	if peer.Origin == s.Name {
+		return "", fmt.Errorf("peer uses our name %s", s.Name)
+	}
+	if peer.Nonce == "" {
+		return "", errors.New("peer sent no nonce")
+	}
+
+	ours, theirs := linkDialer, linkAcceptor
+	dialerNonce, acceptorNonce := hello.Nonce, peer.Nonce
+	if !dialed {
+		ours, theirs = theirs, ours
+		dialerNonce, acceptorNonce = acceptorNonce, dialerNonce
+	}
+	proof := s.linkProof(ours, s.Name, peer.Origin, dialerNonce, acceptorNonce)
+	if err := enc.Encode(handshake{Kind: relayAuth, Origin: s.Name, Proof: proof}); err != nil {
+		return "", err
+	}
+	auth, err := readHandshake(input, relayAuth)
+	if err != nil {
+		return "", err
+	}
+	want := s.linkProof(theirs, peer.Origin, s.Name, dialerNonce, acceptorNonce)
+	if !hmac.Equal([]byte(auth.Proof), []byte(want)) {
+		return "", fmt.Errorf("%s does not know the link secret", peer.Origin)
+	}
+	return peer.Origin, nil
+}
+
+// readHandshake reads the next handshake message of the kind.
+func readHandshake(input *bufio.Scanner, kind string) (handshake, error) {
+	if !input.Scan() {
+		err := input.Err()
+		if err == nil {
+			err = errors.New("connection closed")
+		}
+		return handshake{}, err
+	}
+	var h handshake
+	if err := json.Unmarshal(input.Bytes(), &h); err != nil || h.Kind != kind {
+		return handshake{}, fmt.Errorf("want %s, got %q", kind, input.Text())
+	}
+	return h, nil
+}
+
+// Roles of the servers in the link handshake.
+const (
+	linkDialer   = "dialer"
+	linkAcceptor = "acceptor"
+)
+
+// linkProof returns the proof the prover, in the role, knows the
+// link secret, an HMAC of the role, the names of the prover and the
+// verifier and the nonces of the dialer and the acceptor.
+func (s *Server) linkProof(role, prover, verifier, dialerNonce, acceptorNonce string) string {
+	mac := hmac.New(sha256.New, []byte(s.LinkSecret))
+	for _, field := range []string{role, prover, verifier, dialerNonce, acceptorNonce} {
+		// length prefixed, so no two field lists read the same
+		fmt.Fprintf(mac, "%d:%s|", len(field), field)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,264 @@
+package icq_test
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/icq"
+)
+
+// linkSecret is shared by the linked servers of the tests.
+const linkSecret = "l1nk-s3cret"
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// startLinkedServer starts the server accepting clients and links
+// from other servers. It returns addresses of both listeners.
+func startLinkedServer(t *testing.T, s *icq.Server) (addr, linkAddr string) {
+	t.Helper()
+	ll := listen(t)
+	addr = startServer(t, s)
+	go s.ServeLinks(ll)
+	return addr, ll.Addr().String()
+}
+
+// waitFor sends /who until the user shows up in the room.
+func (c *testClient) waitFor(user string) {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.send("/who")
+		for {
+			line := c.read()
+			if !strings.HasPrefix(line, "* in ") {
+				continue
+			}
+			if strings.Contains(line, user) {
+				return
+			}
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.t.Fatalf("%s did not show up", user)
+}
+
+// nextChat reads lines until the next chat message, skipping notices.
+func (c *testClient) nextChat() string {
+	c.t.Helper()
+	for {
+		line := c.read()
+		if !strings.HasPrefix(line, "* ") {
+			return line
+		}
+	}
+}
+
+func TestFederation_RelaysMessagesAcrossLinkedServers(t *testing.T) {
+	t.Parallel()
+
+	addrA, linkA := startLinkedServer(t, &icq.Server{Name: "A", LinkSecret: linkSecret})
+	addrC, linkC := startLinkedServer(t, &icq.Server{Name: "C", LinkSecret: linkSecret})
+	startServer(t, &icq.Server{Name: "B", LinkSecret: linkSecret, Peers: []string{linkA, linkC}})
+
+	alice := dial(t, addrA)
+	alice.nick("alice")
+	carol := dial(t, addrC)
+	carol.nick("carol")
+
+	carol.waitFor("alice@A")
+	alice.waitFor("carol@C")
+
+	alice.send("hi carol")
+	if got := carol.nextChat(); got != "alice@A: hi carol" {
+		t.Errorf("want relayed message, got %q", got)
+	}
+	carol.send("hi alice")
+	if got := alice.nextChat(); got != "alice: hi carol" {
+		t.Errorf("want own message, got %q", got)
+	}
+	if got := alice.nextChat(); got != "carol@C: hi alice" {
+		t.Errorf("want relayed message, got %q", got)
+	}
+}
+
+func TestFederation_DeliversMessagesOnceWhenLinksFormCycle(t *testing.T) {
+	t.Parallel()
+
+	llA, llB, llC := listen(t), listen(t), listen(t)
+	a := &icq.Server{Name: "A", LinkSecret: linkSecret, Peers: []string{llB.Addr().String()}}
+	b := &icq.Server{Name: "B", LinkSecret: linkSecret, Peers: []string{llC.Addr().String()}}
+	c := &icq.Server{Name: "C", LinkSecret: linkSecret, Peers: []string{llA.Addr().String()}}
+	addrA := startServer(t, a)
+	startServer(t, b)
+	addrC := startServer(t, c)
+	go a.ServeLinks(llA)
+	go b.ServeLinks(llB)
+	go c.ServeLinks(llC)
+
+	alice := dial(t, addrA)
+	alice.nick("alice")
+	carol := dial(t, addrC)
+	carol.waitFor("alice@A")
+
+	alice.send("one")
+	alice.send("two")
+	if got := carol.nextChat(); got != "alice@A: one" {
+		t.Errorf("want first message, got %q", got)
+	}
+	if got := carol.nextChat(); got != "alice@A: two" {
+		t.Errorf("want second message delivered once, got %q", got)
+	}
+}
+
+func TestFederation_ReconnectsLinkWhenPeerComesBack(t *testing.T) {
+	t.Parallel()
+
+	// reserve an address for the link listener started later
+	ll := listen(t)
+	linkA := ll.Addr().String()
+	ll.Close()
+
+	addrB := startServer(t, &icq.Server{Name: "B", LinkSecret: linkSecret, Peers: []string{linkA}})
+	time.Sleep(50 * time.Millisecond)
+
+	a := &icq.Server{Name: "A", LinkSecret: linkSecret, Logger: log.New(io.Discard, "", 0)}
+	addrA := startServer(t, a)
+	ll, err := net.Listen("tcp", linkA)
+	if err != nil {
+		t.Skipf("link address taken in the meantime: %v", err)
+	}
+	t.Cleanup(func() { ll.Close() })
+	go a.ServeLinks(ll)
+
+	alice := dial(t, addrA)
+	alice.nick("alice")
+	bob := dial(t, addrB)
+	bob.waitFor("alice@A")
+}
+
+func TestFederation_RefusesPeerWithWrongSecret(t *testing.T) {
+	t.Parallel()
+
+	addrA, linkA := startLinkedServer(t, &icq.Server{Name: "A", LinkSecret: linkSecret})
+	conn, err := net.Dial("tcp", linkA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// speak the link protocol proving the wrong secret
+	input := bufio.NewScanner(conn)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var hello struct{ Nonce string }
+	if !input.Scan() || json.Unmarshal(input.Bytes(), &hello) != nil || hello.Nonce == "" {
+		t.Fatalf("want hello with a nonce, got %q", input.Text())
+	}
+	fmt.Fprintln(conn, `{"kind":"hello","origin":"B","nonce":"00"}`)
+	mac := hmac.New(sha256.New, []byte("wrong"))
+	mac.Write([]byte(hello.Nonce + "|B"))
+	fmt.Fprintf(conn, `{"kind":"auth","origin":"B","proof":%q}`+"\n", hex.EncodeToString(mac.Sum(nil)))
+	fmt.Fprintln(conn, `{"id":"x","kind":"message","origin":"B","message":{"room":"lobby","from":"mallory","text":"hi"}}`)
+
+	for input.Scan() {
+		// a's auth reply, then the connection is closed
+	}
+	if err := input.Err(); err != nil {
+		t.Errorf("want link closed, got %v", err)
+	}
+	alice := dial(t, addrA)
+	alice.send("/who")
+	if got := alice.read(); got != "* in lobby: "+alice.conn.LocalAddr().String() {
+		t.Errorf("want no users injected over the link, got %q", got)
+	}
+}
+
+func TestFederation_RefusesProofReplayedFromAnotherServer(t *testing.T) {
+	t.Parallel()
+
+	_, linkA := startLinkedServer(t, &icq.Server{Name: "A", LinkSecret: linkSecret})
+	_, linkB := startLinkedServer(t, &icq.Server{Name: "B", LinkSecret: linkSecret})
+	open := func(addr string) (net.Conn, *bufio.Scanner, string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		input := bufio.NewScanner(conn)
+		var hello struct{ Nonce string }
+		if !input.Scan() || json.Unmarshal(input.Bytes(), &hello) != nil || hello.Nonce == "" {
+			t.Fatalf("want hello with a nonce, got %q", input.Text())
+		}
+		return conn, input, hello.Nonce
+	}
+	toB, fromB, nonceB := open(linkB)
+	toA, fromA, nonceA := open(linkA)
+
+	// posing as B, get A to prove the secret over B's nonce
+	fmt.Fprintf(toA, `{"kind":"hello","origin":"B","nonce":%q}`+"\n", nonceB)
+	var auth struct{ Proof string }
+	if !fromA.Scan() || json.Unmarshal(fromA.Bytes(), &auth) != nil || auth.Proof == "" {
+		t.Fatalf("want auth with a proof, got %q", fromA.Text())
+	}
+	// and pass A's proof on to B, posing as A
+	fmt.Fprintf(toB, `{"kind":"hello","origin":"A","nonce":%q}`+"\n", nonceA)
+	fmt.Fprintf(toB, `{"kind":"auth","origin":"A","proof":%q}`+"\n", auth.Proof)
+
+	for fromB.Scan() {
+		// b's auth reply, then the connection is closed
+	}
+	if err := fromB.Err(); err != nil {
+		t.Errorf("want link closed, got %v", err)
+	}
+}
+
+func TestFederation_RefusesLinksWithoutSecret(t *testing.T) {
+	t.Parallel()
+
+	s := &icq.Server{Name: "A", Peers: []string{"127.0.0.1:1"}}
+	if err := s.Serve(listen(t)); err == nil {
+		t.Error("want error linking without a secret")
+	}
+}
+
+func TestFederation_LinksOverMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := icq.GenerateCert(certFile, keyFile, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := icq.LoadLinkTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrA, linkA := startLinkedServer(t, &icq.Server{Name: "A", LinkSecret: linkSecret, LinkTLSConfig: cfg})
+	addrB := startServer(t, &icq.Server{Name: "B", LinkSecret: linkSecret, LinkTLSConfig: cfg, Peers: []string{linkA}})
+
+	alice := dial(t, addrA)
+	alice.nick("alice")
+	bob := dial(t, addrB)
+	bob.waitFor("alice@A")
+}
@@ -0,0 +1,62 @@
+package icq
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+//go:embed static/index.html
+var indexHTML []byte
+
+// Handler returns an HTTP handler bridging browser clients into
+// the chat. It serves a tiny chat page at "/" and upgrades
+// requests to "/ws" to WebSocket connections. Browser clients
+// share rooms with clients connected over TCP.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	})
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	return mux
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if err := s.setup(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conn, err := upgrade(w, r)
+	if err != nil {
+		s.Logger.Print(err)
+		return
+	}
+	s.serve(r.RemoteAddr, conn, jsonProtocol)
+}
+
+// writeJSON writes the message as a JSON document.
+func writeJSON(w io.Writer, m Message) error {
+	return json.NewEncoder(w).Encode(m)
+}
+
+// ListenAndServeHTTP listens on the TCP network address addr
+// and serves the WebSocket gateway. The gateway uses the server's
+// TLS configuration when it is set.
+func (s *Server) ListenAndServeHTTP(addr string) error {
+	if err := s.setup(); err != nil {
+		return err
+	}
+	s.Logger.Printf("serving websocket gateway on " + addr)
+	hs := http.Server{Addr: addr, Handler: s.Handler(), TLSConfig: s.TLSConfig}
+	if s.TLSConfig != nil {
+		return hs.ListenAndServeTLS("", "")
+	}
+	return hs.ListenAndServe()
+}
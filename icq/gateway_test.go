@@ -0,0 +1,140 @@
+package icq_test
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/icq"
+)
+
+// wsClient is a minimal WebSocket client speaking just enough
+// of the protocol to exchange short text messages.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func dialWS(t *testing.T, url string) *wsClient {
+	t.Helper()
+	addr := strings.TrimPrefix(url, "http://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", addr, base64.StdEncoding.EncodeToString(key))
+
+	rd := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("want status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	return &wsClient{t: t, conn: conn, rd: rd}
+}
+
+func (c *wsClient) send(text string) {
+	c.t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x81, 0x80 | byte(len(text))}
+	frame = append(frame, mask...)
+	for i := range text {
+		frame = append(frame, text[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) read() icq.Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(c.rd, hdr); err != nil {
+		c.t.Fatal(err)
+	}
+	size := int(hdr[1] & 0x7f)
+	if size == 126 {
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.rd, ext); err != nil {
+			c.t.Fatal(err)
+		}
+		size = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.rd, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	var m icq.Message
+	if err := json.Unmarshal(payload, &m); err != nil {
+		c.t.Fatalf("decoding %q: %v", payload, err)
+	}
+	return m
+}
+
+func TestHandler_BridgesWebSocketAndTCPClients(t *testing.T) {
+	t.Parallel()
+
+	s := &icq.Server{}
+	addr := startServer(t, s)
+	web := httptest.NewServer(s.Handler())
+	t.Cleanup(web.Close)
+
+	browser := dialWS(t, web.URL)
+	m := browser.read()
+	if !strings.HasPrefix(m.Text, "Connected new client: ") {
+		t.Fatalf("unexpected greeting %q", m.Text)
+	}
+
+	tcp := dial(t, addr)
+	if m := browser.read(); !strings.HasSuffix(m.Text, " has joined conversation") {
+		t.Fatalf("want join notice, got %q", m.Text)
+	}
+
+	tcp.send("hello from tcp")
+	m = browser.read()
+	if m.From != tcp.conn.LocalAddr().String() || m.Text != "hello from tcp" || m.Room != icq.DefaultRoom {
+		t.Errorf("unexpected message %+v", m)
+	}
+	tcp.expectPrefix(tcp.conn.LocalAddr().String() + ": hello from tcp")
+
+	browser.send("hello from browser")
+	tcp.expectPrefix(browser.conn.LocalAddr().String() + ": hello from browser")
+}
+
+func TestHandler_ServesChatPage(t *testing.T) {
+	t.Parallel()
+
+	web := httptest.NewServer((&icq.Server{}).Handler())
+	t.Cleanup(web.Close)
+
+	resp, err := http.Get(web.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "new WebSocket") {
+		t.Errorf("unexpected response %d: %s", resp.StatusCode, body)
+	}
+}
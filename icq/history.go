@@ -0,0 +1,105 @@
+package icq
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// history keeps the most recent messages posted to a room
+// in a fixed size ring buffer. When the buffer is full the
+// oldest message is overwritten by the newest one.
+type history struct {
+	msgs []Message
+	next int  // index of the slot the next message goes to
+	full bool // true once the buffer wrapped around
+}
+
+func newHistory(size int) *history {
+	return &history{msgs: make([]Message, size)}
+}
+
+// add stores the message in the buffer.
+func (h *history) add(m Message) {
+	if len(h.msgs) == 0 {
+		return
+	}
+	h.msgs[h.next] = m
+	h.next = (h.next + 1) % len(h.msgs)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// len returns number of messages currently held in the buffer.
+func (h *history) len() int {
+	if h.full {
+		return len(h.msgs)
+	}
+	return h.next
+}
+
+// last returns up to n most recent messages, oldest first.
+func (h *history) last(n int) []Message {
+	size := h.len()
+	if n > size || n < 0 {
+		n = size
+	}
+	out := make([]Message, 0, n)
+	start := h.next - n
+	if start < 0 {
+		start += len(h.msgs)
+	}
+	for i := 0; i < n; i++ {
+		out = append(out, h.msgs[(start+i)%len(h.msgs)])
+	}
+	return out
+}
+
+// journal is an append-only file holding all recorded messages,
+// one JSON document per line. It lets the server restore
+// room histories after a restart.
+type journal struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// openJournal opens (or creates) the journal file and replays
+// all messages already stored in it to the load func.
+func openJournal(path string, load func(Message)) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := readJournal(f, load); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading history file %s: %w", path, err)
+	}
+	return &journal{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func readJournal(r io.Reader, load func(Message)) error {
+	input := bufio.NewScanner(r)
+	for input.Scan() {
+		if len(input.Bytes()) == 0 {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(input.Bytes(), &m); err != nil {
+			return err
+		}
+		load(m)
+	}
+	return input.Err()
+}
+
+// append writes the message at the end of the journal.
+func (j *journal) append(m Message) error {
+	return j.enc.Encode(m)
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
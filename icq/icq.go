@@ -2,101 +2,643 @@ package icq
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-type client chan<- string // outgoing message channel
+// DefaultRoom is the room every client joins after connecting.
+const DefaultRoom = "lobby"
 
-var (
-	entering = make(chan client)
-	leaving  = make(chan client)
-	messages = make(chan string) // all incoming client messages
-	errors   = make(chan error)
-)
+// Message represents a single message posted to a room.
+// Messages without a sender and kind are system notifications.
+type Message struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind,omitempty"`
+	Room string    `json:"room"`
+	From string    `json:"from,omitempty"`
+	Text string    `json:"text"`
+}
+
+// String formats the message as a line of the text protocol.
+// System notifications are prefixed with an asterisk.
+func (m Message) String() string {
+	switch {
+	case m.Kind == KindPing:
+		return "/ping"
+	case m.Kind == KindPresence:
+		return "* " + m.From + " is " + m.Text
+	case m.Kind == KindTyping:
+		return "* " + m.From + " is typing"
+	case m.From == "":
+		return "* " + m.Text
+	}
+	return m.From + ": " + m.Text
+}
+
+// client represents a connected user. The who, room
+// and rate limiting fields are owned by the messanger goroutine.
+type client struct {
+	who  string
+	host string // network address the client connects from
+	room string
+	out  chan Message // outgoing message channel
+
+	proto    protocol
+	status   string // presence state
+	operator bool   // authenticated as one of the operators
+
+	// disconnect stops reading input from the client
+	// which ends the client's session.
+	disconnect func()
+
+	// cutOff stops reading from and writing to the client
+	// which no longer responds, ending the session.
+	cutOff func()
+
+	tokens float64   // available messages under the rate limit
+	last   time.Time // last time tokens were refilled
+}
+
+// post is a line of input received from a client.
+type post struct {
+	cl   *client
+	text string
+}
+
+// Server is an ICQ Chat Server.
+type Server struct {
+	// Addr is a TCP address to listen on, "localhost:8000" if empty.
+	Addr string
+
+	// HistorySize is a number of recent messages kept per room
+	// and replayed to clients when they join the room.
+	HistorySize int
+
+	// HistoryFile, if set, is an append-only file the messages
+	// are persisted to. The file is read on start to restore
+	// room histories.
+	HistoryFile string
+
+	// Auth, if set, verifies credentials clients present
+	// with the /auth command before joining the chat.
+	Auth Authenticator
+
+	// TLSConfig, if set, makes the server accept TLS connections only.
+	TLSConfig *tls.Config
+
+	// RateLimit is a number of messages per second a client can post,
+	// with bursts of up to RateBurst messages. Zero means no limit.
+	RateLimit float64
+	RateBurst int
+
+	// MaxLineLength is the longest message, in bytes,
+	// a client can post. Zero means no limit.
+	MaxLineLength int
+
+	// Operators are users allowed to kick, ban and mute other
+	// users. Operator rights are given when a user authenticates,
+	// so setting Operators requires Auth.
+	Operators []string
+
+	// Name identifies the server among linked servers.
+	// It defaults to Addr and must be unique in the federation.
+	Name string
+
+	// LinkAddr, if set, is a TCP address to accept links
+	// from other servers on.
+	LinkAddr string
+
+	// Peers are link addresses of other servers to link with.
+	Peers []string
+
+	// LinkSecret is shared by all linked servers, which prove
+	// to each other they know it. Links are refused without it.
+	LinkSecret string
+
+	// LinkTLSConfig, if set, encrypts links. It is used both to
+	// accept and to dial links, so for mutual TLS it holds the
+	// server's certificate, ClientCAs, RootCAs and ClientAuth.
+	LinkTLSConfig *tls.Config
+
+	// Bots are automated participants attached to the server.
+	Bots []Bot
+
+	// HeartbeatInterval, if set, is how often clients are pinged.
+	// Clients not sending anything, including "/pong" replies,
+	// for HeartbeatTimeout (3 intervals by default) are disconnected.
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+
+	Logger *log.Logger
+
+	entering chan *client
+	leaving  chan *client
+	posts    chan post    // all incoming client messages
+	botPosts chan Message // all messages posted by bots
+
+	linksUp   chan *link
+	linksDown chan *link
+	relays    chan incoming // all relays received from linked servers
+	instance  string        // unique prefix of relay IDs
+
+	rooms   map[string]*history
+	journal *journal
+
+	once     sync.Once
+	setupErr error
+}
+
+// setup initializes the server and starts the messanger.
+// It is safe to call it multiple times.
+func (s *Server) setup() error {
+	s.once.Do(func() {
+		s.setupErr = s.init()
+		if s.setupErr == nil {
+			go s.messanger()
+			for _, p := range s.Peers {
+				go s.dialPeer(p)
+			}
+		}
+	})
+	return s.setupErr
+}
+
+func (s *Server) init() error {
+	if s.HistorySize < 0 {
+		return fmt.Errorf("icq: history size %d, want at least 0", s.HistorySize)
+	}
+	if len(s.Operators) > 0 && s.Auth == nil {
+		return errors.New("icq: operators require an authenticator, anyone can take their nicks")
+	}
+	if (s.LinkAddr != "" || len(s.Peers) > 0) && s.LinkSecret == "" {
+		return errNoLinkSecret
+	}
+	if s.Addr == "" {
+		s.Addr = "localhost:8000"
+	}
+	if s.Logger == nil {
+		s.Logger = log.New(os.Stdout, "ICQ:", log.Lshortfile)
+	}
+	s.entering = make(chan *client)
+	s.leaving = make(chan *client)
+	s.posts = make(chan post)
+	s.botPosts = make(chan Message)
+	s.linksUp = make(chan *link)
+	s.linksDown = make(chan *link)
+	s.relays = make(chan incoming)
+	if s.Name == "" {
+		s.Name = s.Addr
+	}
+	s.instance = fmt.Sprintf("%s/%d", s.Name, time.Now().UnixNano())
+	s.rooms = make(map[string]*history)
+
+	if s.HistoryFile != "" {
+		j, err := openJournal(s.HistoryFile, s.record)
+		if err != nil {
+			return err
+		}
+		s.journal = j
+	}
+	return nil
+}
+
+// record stores the message in the room history.
+func (s *Server) record(m Message) {
+	h, ok := s.rooms[m.Room]
+	if !ok {
+		h = newHistory(s.HistorySize)
+		s.rooms[m.Room] = h
+	}
+	h.add(m)
+}
+
+// hub holds the chat state owned by the messanger goroutine.
+type hub struct {
+	// clients represents all connected clients to the ICQ server
+	clients map[*client]bool
+
+	banned map[string]bool // banned nicks and hosts
+	muted  map[string]bool // muted nicks
+
+	fed  *federation
+	bots []*botClient
+}
+
+// broadcast sends the message to all clients and bots in the message's
+// room. Typing notifications go only to clients that want them.
+func (h *hub) broadcast(m Message) {
+	for _, b := range h.bots {
+		b.deliver(m)
+	}
+	for cl := range h.clients {
+		if m.Kind == KindTyping && !cl.proto.typing {
+			continue
+		}
+		if cl.room == m.Room {
+			cl.out <- m
+		}
+	}
+}
+
+// find returns the connected client using the nick.
+func (h *hub) find(nick string) *client {
+	for cl := range h.clients {
+		if cl.who == nick {
+			return cl
+		}
+	}
+	return nil
+}
 
 // messanger broadcast messages to connected clients
 // and adds/removes clients from the pool.
-func messanger() {
-	// clients represents all connected clients to the ICQ server
-	clients := make(map[client]bool)
+func (s *Server) messanger() {
+	h := &hub{
+		clients: make(map[*client]bool),
+		banned:  make(map[string]bool),
+		muted:   make(map[string]bool),
+		fed:     newFederation(),
+	}
+	s.startBots(h)
 
 	for {
 		select {
-		case msg := <-messages:
-			// broadcast a message to all clients' outgoing message channels
-			for cl := range clients {
-				cl <- msg
+		case p := <-s.posts:
+			// ignore clients that were kicked or not let in
+			if !h.clients[p.cl] {
+				continue
 			}
+			if !control(p.text) && !s.allow(p.cl, time.Now()) {
+				p.cl.out <- s.notice(p.cl.room, "slow down, message dropped")
+				continue
+			}
+			if strings.HasPrefix(p.text, "/") {
+				s.command(h, p.cl, p.text)
+				continue
+			}
+			if reason := s.check(h, p.cl, p.text); reason != "" {
+				p.cl.out <- s.notice(p.cl.room, reason)
+				continue
+			}
+			m := Message{Time: time.Now(), Room: p.cl.room, From: p.cl.who, Text: p.text}
+			s.publish(h, m)
+			s.relay(h, relayMessage, m)
+
+		case m := <-s.botPosts:
+			s.publish(h, m)
+			s.relay(h, relayMessage, m)
 
 		// a new client connects to the server:
+		//  - replay the room history to the client
 		//  - add it to the client pool
-		case cl := <-entering:
-			clients[cl] = true
+		case cl := <-s.entering:
+			if h.isBanned(cl) {
+				cl.out <- s.notice(cl.room, "you are banned from this server")
+				cl.disconnect()
+				continue
+			}
+			if h.find(cl.who) != nil || h.isBot(cl.who) {
+				cl.out <- s.notice(cl.room, cl.who+" is already connected")
+				cl.disconnect()
+				continue
+			}
+			s.replay(cl, -1)
+			s.joined(h, cl)
+			h.clients[cl] = true
 
 		// a client disconnects from the server
 		//  - remove it from the pool
 		//  - close the channel (client) the client uses to communicate with the server
-		case cl := <-leaving:
-			delete(clients, cl)
-			close(cl)
+		case cl := <-s.leaving:
+			close(cl.out)
+			if !h.clients[cl] {
+				continue
+			}
+			delete(h.clients, cl)
+			s.left(h, cl, cl.room, "has left")
+
+		case l := <-s.linksUp:
+			s.linkUp(h, l)
+		case l := <-s.linksDown:
+			s.linkDown(h, l)
+		case in := <-s.relays:
+			s.receive(h, in)
+		}
+	}
+}
+
+// publish records the chat message in the room history
+// and broadcasts it to the room.
+func (s *Server) publish(h *hub, m Message) {
+	s.record(m)
+	if s.journal != nil {
+		if err := s.journal.append(m); err != nil {
+			s.Logger.Print(err)
+		}
+	}
+	h.broadcast(m)
+}
+
+// joined announces the client joined its room
+// to local users and to linked servers.
+func (s *Server) joined(h *hub, cl *client) {
+	h.broadcast(s.notice(cl.room, cl.who+" has joined conversation"))
+	s.relay(h, relayJoin, Message{Time: time.Now(), Room: cl.room, From: cl.who})
+}
+
+// left announces the client left the room
+// to local users and to linked servers.
+func (s *Server) left(h *hub, cl *client, room, reason string) {
+	h.broadcast(s.notice(room, cl.who+" "+reason))
+	s.relay(h, relayLeave, Message{Time: time.Now(), Room: room, From: cl.who})
+}
+
+// command executes a slash command sent by the client.
+func (s *Server) command(h *hub, cl *client, line string) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "/history":
+		n := -1
+		if len(fields) > 1 {
+			v, err := strconv.Atoi(fields[1])
+			if err != nil || v < 0 {
+				cl.out <- s.notice(cl.room, "usage: /history [N]")
+				return
+			}
+			n = v
+		}
+		s.replay(cl, n)
+	case "/join":
+		if len(fields) != 2 {
+			cl.out <- s.notice(cl.room, "usage: /join ROOM")
+			return
+		}
+		old := cl.room
+		cl.room = fields[1]
+		s.left(h, cl, old, "has left")
+		s.replay(cl, -1)
+		s.joined(h, cl)
+	case "/nick":
+		if s.Auth != nil {
+			cl.out <- s.notice(cl.room, "nick is bound to your account")
+			return
 		}
+		if len(fields) != 2 || !validNick(fields[1]) {
+			cl.out <- s.notice(cl.room, "usage: /nick NAME")
+			return
+		}
+		nick := fields[1]
+		if other := h.find(nick); (other != nil && other != cl) || h.isBot(nick) {
+			cl.out <- s.notice(cl.room, "nick "+nick+" is taken")
+			return
+		}
+		if h.banned[nick] {
+			cl.out <- s.notice(cl.room, "nick "+nick+" is banned")
+			return
+		}
+		old := cl.who
+		cl.who = nick
+		h.broadcast(s.notice(cl.room, old+" is now known as "+nick))
+		s.relay(h, relayLeave, Message{Time: time.Now(), Room: cl.room, From: old})
+		s.relay(h, relayJoin, Message{Time: time.Now(), Room: cl.room, From: nick})
+	case "/away":
+		s.setPresence(h, cl, awayStatus(fields))
+	case "/back":
+		s.setPresence(h, cl, Online)
+	case "/typing":
+		s.typing(h, cl)
+	case "/who":
+		cl.out <- s.notice(cl.room, "in "+cl.room+": "+strings.Join(h.who(cl.room), ", "))
+	case "/kick", "/ban", "/unban", "/mute", "/unmute":
+		s.moderate(h, cl, fields)
+	default:
+		cl.out <- s.notice(cl.room, "unknown command "+fields[0])
+	}
+}
+
+// replay sends up to n most recent messages from the client's room
+// to the client. Negative n replays the whole room history.
+func (s *Server) replay(cl *client, n int) {
+	h, ok := s.rooms[cl.room]
+	if !ok {
+		return
+	}
+	for _, m := range h.last(n) {
+		cl.out <- m
 	}
 }
 
-func handleConnection(conn net.Conn) {
+// validNick reports whether the name can be used as a nick.
+// Nicks can't contain spaces or colons so chat lines
+// can be parsed unambiguously.
+func validNick(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ": \t")
+}
+
+func (s *Server) notice(room, text string) Message {
+	return Message{Time: time.Now(), Room: room, Text: text}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	s.serve(conn.RemoteAddr().String(), conn, textProtocol)
+}
+
+// serve runs a chat session for the client talking over conn.
+// Incoming lines are read from conn and outgoing messages are
+// written to conn as defined by the protocol.
+func (s *Server) serve(who string, conn io.ReadWriteCloser, proto protocol) {
 	// outgoing client messages
 	// the channel represents a new client that will be registered in the clients map in the func messanger.
-	ch := make(chan string)
-	go clientWriter(conn, ch)
+	cl := &client{
+		who:        who,
+		host:       hostOf(who),
+		room:       DefaultRoom,
+		out:        make(chan Message),
+		disconnect: stopReading(conn),
+		cutOff:     cutOff(conn),
+		proto:      proto,
+		status:     Online,
+	}
+	written := make(chan struct{})
+	go func() {
+		s.clientWriter(conn, cl.out, proto.encode, cl.cutOff)
+		close(written)
+	}()
 
-	who := conn.RemoteAddr().String()
-	ch <- "Connected new client: " + who
-	messages <- who + " has joined conversation"
-	entering <- ch
+	cl.out <- s.notice(cl.room, "Connected new client: "+who)
+
+	activity := newActivityReader(conn)
+	stopHeartbeat := func() {}
+	if s.HeartbeatInterval > 0 {
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			s.heartbeat(cl, activity, stop)
+			close(stopped)
+		}()
+		stopHeartbeat = func() {
+			close(stop)
+			<-stopped
+		}
+	}
+
+	input := bufio.NewScanner(activity)
+	if s.Auth != nil {
+		name, ok := s.authenticate(cl, input)
+		if !ok {
+			stopHeartbeat()
+			close(cl.out)
+			<-written
+			conn.Close()
+			return
+		}
+		cl.who = name
+		cl.operator = s.isOperator(name)
+	}
+	s.entering <- cl
 
-	input := bufio.NewScanner(conn)
 	for input.Scan() {
-		messages <- who + ": " + input.Text()
+		if input.Text() == "/pong" {
+			continue
+		}
+		s.posts <- post{cl: cl, text: input.Text()}
 	}
 
-	leaving <- ch
-	messages <- who + " has left"
+	stopHeartbeat()
+	s.leaving <- cl
+	<-written // let the client writer flush pending messages
 	conn.Close()
 }
 
+// maxAuthAttempts is a number of failed /auth commands
+// after which the client is disconnected.
+const maxAuthAttempts = 3
+
+// authenticate asks the client for credentials and verifies them
+// with the server's authenticator. It returns the user name
+// the client authenticated as.
+func (s *Server) authenticate(cl *client, input *bufio.Scanner) (string, bool) {
+	cl.out <- s.notice(cl.room, "authentication required: /auth USER SECRET")
+	for attempt := 0; attempt < maxAuthAttempts && input.Scan(); attempt++ {
+		if input.Text() == "/pong" {
+			attempt--
+			continue
+		}
+		fields := strings.Fields(input.Text())
+		if len(fields) != 3 || fields[0] != "/auth" || !validNick(fields[1]) {
+			cl.out <- s.notice(cl.room, "usage: /auth USER SECRET")
+			continue
+		}
+		if err := s.Auth.Authenticate(fields[1], fields[2]); err != nil {
+			s.Logger.Printf("authentication of %s as %s failed: %v", cl.who, fields[1], err)
+			cl.out <- s.notice(cl.room, "authentication failed")
+			continue
+		}
+		cl.out <- s.notice(cl.room, "authenticated as "+fields[1])
+		return fields[1], true
+	}
+	return "", false
+}
+
+// encoder writes a message to the client connection.
+type encoder func(io.Writer, Message) error
+
+// writeText writes the message as a line of plain text.
+func writeText(w io.Writer, m Message) error {
+	_, err := fmt.Fprintln(w, m)
+	return err
+}
+
+// clientWriteTimeout is how long writing a message
+// to a client may take before the client is cut off.
+const clientWriteTimeout = 10 * time.Second
+
 // clientWriter writes messages comming from the channel
-// to the provided connection.
-func clientWriter(conn net.Conn, ch <-chan string) {
+// to the provided connection. When a write fails, it calls
+// failed and then drops the messages until the channel is
+// closed, so the senders never block on a dead client.
+func (s *Server) clientWriter(conn io.Writer, ch <-chan Message, encode encoder, failed func()) {
+	deadline, _ := conn.(interface{ SetWriteDeadline(time.Time) error })
+	broken := false
 	for msg := range ch {
-		_, err := fmt.Fprintln(conn, msg)
+		if broken {
+			continue
+		}
+		if deadline != nil {
+			deadline.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		}
+		if err := encode(conn, msg); err != nil {
+			s.Logger.Print(err)
+			broken = true
+			failed()
+		}
+	}
+}
+
+// ListenAndServe listens on the TCP network address s.Addr
+// and then calls Serve to handle incoming connections.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = "localhost:8000"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.TLSConfig != nil {
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+	if s.LinkAddr != "" {
+		ll, err := net.Listen("tcp", s.LinkAddr)
 		if err != nil {
-			errors <- err
+			l.Close()
+			return err
 		}
+		go func() {
+			if err := s.ServeLinks(ll); err != nil {
+				s.Logger.Print(err)
+			}
+		}()
 	}
+	return s.Serve(l)
 }
 
-// RunServer starts a new ICQ Chat Server.
-// The main job of the function is to listen for and accept new incoming
-// newtwork connections from clients. For each connection the func creates
+// Serve accepts incoming connections on the listener l.
+// The main job of the func is to accept new incoming newtwork
+// connections from clients. For each connection the func creates
 // a new handleConnection goroutine.
-func RunServer() {
-	icqlog := log.New(os.Stdout, "ICQ:", log.Lshortfile)
-	l, err := net.Listen("tcp", "localhost:8000")
-	if err != nil {
-		icqlog.Fatal(err)
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.setup(); err != nil {
+		return err
 	}
-	icqlog.Printf("listening on " + l.Addr().String())
+	s.Logger.Printf("listening on " + l.Addr().String())
 
-	go messanger()
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			icqlog.Print(err)
-			continue
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.Logger.Print(err)
+				continue
+			}
+			return err
 		}
-		go handleConnection(conn)
+		go s.handleConnection(conn)
+	}
+}
+
+// RunServer starts a new ICQ Chat Server on localhost:8000.
+func RunServer() {
+	s := Server{HistorySize: 100}
+	if err := s.ListenAndServe(); err != nil {
+		log.Fatal(err)
 	}
 }
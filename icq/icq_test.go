@@ -0,0 +1,169 @@
+package icq_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/icq"
+)
+
+func startServer(t *testing.T, s *icq.Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s.Logger = log.New(io.Discard, "", 0)
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+type testClient struct {
+	t     *testing.T
+	conn  net.Conn
+	input *bufio.Scanner
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{t: t, conn: conn, input: bufio.NewScanner(conn)}
+	c.expectPrefix("* Connected new client: ")
+	return c
+}
+
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintln(c.conn, line); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if !c.input.Scan() {
+		c.t.Fatalf("reading line: %v", c.input.Err())
+	}
+	return c.input.Text()
+}
+
+func (c *testClient) expectPrefix(prefix string) string {
+	c.t.Helper()
+	line := c.read()
+	if !strings.HasPrefix(line, prefix) {
+		c.t.Fatalf("want line starting with %q, got %q", prefix, line)
+	}
+	return line
+}
+
+// chat sends lines to the server and waits for the server
+// to broadcast them back, so they are recorded in history.
+func (c *testClient) chat(lines ...string) {
+	c.t.Helper()
+	for _, l := range lines {
+		c.send(l)
+		c.expectPrefix(c.conn.LocalAddr().String() + ": " + l)
+	}
+}
+
+func (c *testClient) readN(n int) []string {
+	c.t.Helper()
+	var got []string
+	for i := 0; i < n; i++ {
+		got = append(got, c.read())
+	}
+	return got
+}
+
+func TestServer_RefusesNegativeHistorySize(t *testing.T) {
+	t.Parallel()
+
+	s := &icq.Server{HistorySize: -1}
+	if err := s.Serve(listen(t)); err == nil {
+		t.Error("want error serving with a negative history size")
+	}
+}
+
+func TestServer_ReplaysRecentMessagesToJoiningClient(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{HistorySize: 2})
+	alice := dial(t, addr)
+	alice.chat("one", "two", "three")
+
+	bob := dial(t, addr)
+	who := alice.conn.LocalAddr().String()
+	want := []string{who + ": two", who + ": three"}
+	got := bob.readN(2)
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_HistoryCommandReturnsLastNMessages(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{HistorySize: 10})
+	alice := dial(t, addr)
+	alice.chat("one", "two", "three")
+
+	alice.send("/history 2")
+	who := alice.conn.LocalAddr().String()
+	want := []string{who + ": two", who + ": three"}
+	got := alice.readN(2)
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_KeepsHistoryPerRoom(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{HistorySize: 10})
+	alice := dial(t, addr)
+	alice.chat("in lobby")
+	alice.send("/join games")
+	alice.expectPrefix("* " + alice.conn.LocalAddr().String() + " has joined conversation")
+	alice.chat("in games")
+
+	bob := dial(t, addr)
+	bob.send("/join games")
+	who := alice.conn.LocalAddr().String()
+	want := []string{who + ": in lobby", who + ": in games"}
+	got := bob.readN(2)
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestServer_RestoresHistoryFromFile(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+
+	addr := startServer(t, &icq.Server{HistorySize: 10, HistoryFile: file})
+	alice := dial(t, addr)
+	alice.chat("persisted")
+
+	addr = startServer(t, &icq.Server{HistorySize: 10, HistoryFile: file})
+	bob := dial(t, addr)
+	want := alice.conn.LocalAddr().String() + ": persisted"
+	got := bob.read()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
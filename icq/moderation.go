@@ -0,0 +1,165 @@
+package icq
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// check enforces the mute list and the length limit on a chat
+// message posted by the client. It returns the reason the
+// message is rejected, or an empty string if it is accepted.
+// Flood control applies to all posts, commands included,
+// but control posts.
+func (s *Server) check(h *hub, cl *client, text string) string {
+	if h.muted[cl.who] {
+		return "you are muted"
+	}
+	if s.MaxLineLength > 0 && len(text) > s.MaxLineLength {
+		return fmt.Sprintf("message too long, max %d bytes", s.MaxLineLength)
+	}
+	return ""
+}
+
+// control reports whether the post is one clients send on their
+// own, like typing notifications, which the rate limit lets through
+// so they don't use up the tokens of the user's messages.
+func control(text string) bool {
+	cmd, _, _ := strings.Cut(text, " ")
+	return cmd == "/typing" || cmd == "/pong"
+}
+
+// allow reports whether the client can post another message under
+// the server's rate limit. It uses a token bucket holding up to
+// RateBurst tokens, refilled at RateLimit tokens per second.
+func (s *Server) allow(cl *client, now time.Time) bool {
+	if s.RateLimit <= 0 {
+		return true
+	}
+	burst := float64(s.RateBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	if cl.last.IsZero() {
+		cl.tokens = burst
+	} else {
+		cl.tokens += now.Sub(cl.last).Seconds() * s.RateLimit
+		if cl.tokens > burst {
+			cl.tokens = burst
+		}
+	}
+	cl.last = now
+	if cl.tokens < 1 {
+		return false
+	}
+	cl.tokens--
+	return true
+}
+
+// isOperator reports whether the authenticated user is an operator.
+func (s *Server) isOperator(user string) bool {
+	for _, op := range s.Operators {
+		if op == user {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hub) isBanned(cl *client) bool {
+	return h.banned[cl.who] || h.banned[cl.host]
+}
+
+// moderate executes operator commands:
+//
+//	/kick NICK       disconnect the user
+//	/ban NICK|ADDR   disconnect matching users and keep them out
+//	/unban NICK|ADDR lift the ban
+//	/mute NICK       drop all messages posted by the user
+//	/unmute NICK     lift the mute
+func (s *Server) moderate(h *hub, op *client, fields []string) {
+	if !op.operator {
+		op.out <- s.notice(op.room, "permission denied")
+		return
+	}
+	if len(fields) != 2 {
+		op.out <- s.notice(op.room, "usage: "+fields[0]+" NICK")
+		return
+	}
+	cmd, target := fields[0], fields[1]
+
+	switch cmd {
+	case "/kick":
+		cl := h.find(target)
+		if cl == nil {
+			op.out <- s.notice(op.room, "no such user "+target)
+			return
+		}
+		s.kick(h, cl, "kicked by "+op.who)
+	case "/ban":
+		if ip := net.ParseIP(target); ip != nil {
+			target = ip.String()
+		}
+		h.banned[target] = true
+		for cl := range h.clients {
+			if h.isBanned(cl) && !cl.operator {
+				s.kick(h, cl, "banned by "+op.who)
+			}
+		}
+		op.out <- s.notice(op.room, target+" is banned")
+	case "/unban":
+		if ip := net.ParseIP(target); ip != nil {
+			target = ip.String()
+		}
+		delete(h.banned, target)
+		op.out <- s.notice(op.room, target+" is no longer banned")
+	case "/mute":
+		h.muted[target] = true
+		op.out <- s.notice(op.room, target+" is muted")
+	case "/unmute":
+		delete(h.muted, target)
+		op.out <- s.notice(op.room, target+" is no longer muted")
+	}
+}
+
+// kick removes the client from the pool and disconnects it.
+func (s *Server) kick(h *hub, cl *client, reason string) {
+	cl.out <- s.notice(cl.room, "you have been "+reason)
+	delete(h.clients, cl)
+	cl.disconnect()
+	s.left(h, cl, cl.room, "has been "+reason)
+}
+
+// hostOf returns the host part of the network address.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// cutOff returns a func that unblocks pending and future reads
+// from and writes to the connection of a client which stopped
+// responding.
+func cutOff(conn io.Closer) func() {
+	if c, ok := conn.(interface{ SetDeadline(time.Time) error }); ok {
+		return func() { c.SetDeadline(time.Now()) }
+	}
+	return func() { conn.Close() }
+}
+
+// stopReading returns a func that unblocks pending and future
+// reads from the connection, leaving it open for writing, so
+// the client can still be told why it is disconnected.
+func stopReading(conn io.Closer) func() {
+	if c, ok := conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return func() { c.SetReadDeadline(time.Now()) }
+	}
+	return func() { conn.Close() }
+}
@@ -0,0 +1,183 @@
+package icq_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/qba73/gocp/icq"
+)
+
+func (c *testClient) nick(name string) {
+	c.t.Helper()
+	c.send("/nick " + name)
+	c.skipUntil("* " + c.conn.LocalAddr().String() + " is now known as " + name)
+}
+
+// skipUntil reads lines until it finds the one starting with prefix.
+func (c *testClient) skipUntil(prefix string) {
+	c.t.Helper()
+	for !strings.HasPrefix(c.read(), prefix) {
+	}
+}
+
+func (c *testClient) expectClosed() {
+	c.t.Helper()
+	if c.input.Scan() {
+		c.t.Fatalf("want connection closed, got %q", c.input.Text())
+	}
+}
+
+// login dials the server and authenticates as the user
+// whose password is "pw".
+func login(t *testing.T, addr, user string) *testClient {
+	t.Helper()
+	c := dial(t, addr)
+	c.expectPrefix("* authentication required")
+	c.send("/auth " + user + " pw")
+	c.expectPrefix("* authenticated as " + user)
+	return c
+}
+
+// moderated returns a server with an operator and
+// users logging in with the password "pw".
+func moderated() *icq.Server {
+	return &icq.Server{
+		Auth:      icq.Passwords{"op": "pw", "bob": "pw"},
+		Operators: []string{"op"},
+	}
+}
+
+func TestServer_DropsMessagesOverRateLimit(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{RateLimit: 0.01, RateBurst: 3})
+	c := dial(t, addr)
+	c.nick("alice")
+	c.send("one")
+	c.send("two")
+	c.send("three")
+	c.expectPrefix("alice: one")
+	c.expectPrefix("alice: two")
+	c.expectPrefix("* slow down, message dropped")
+}
+
+func TestServer_RateLimitsCommands(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{RateLimit: 0.01, RateBurst: 1})
+	c := dial(t, addr)
+	c.send("/who")
+	c.expectPrefix("* in lobby: ")
+	c.send("/nick alice")
+	c.expectPrefix("* slow down, message dropped")
+}
+
+func TestServer_DoesNotRateLimitTypingNotifications(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{RateLimit: 0.01, RateBurst: 1})
+	c := dial(t, addr)
+	for i := 0; i < 3; i++ {
+		c.send("/typing")
+	}
+	c.send("hi")
+	c.expectPrefix(c.conn.LocalAddr().String() + ": hi")
+}
+
+func TestServer_RejectsMessagesOverMaxLineLength(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{MaxLineLength: 5})
+	c := dial(t, addr)
+	c.nick("alice")
+	c.send("too long")
+	c.expectPrefix("* message too long, max 5 bytes")
+	c.send("short")
+	c.expectPrefix("alice: short")
+}
+
+func TestServer_RefusesOperatorsWithoutAuth(t *testing.T) {
+	t.Parallel()
+
+	s := &icq.Server{Operators: []string{"op"}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := s.Serve(l); err == nil {
+		t.Error("want error serving operators without an authenticator")
+	}
+}
+
+func TestServer_OnlyOperatorsCanModerate(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, moderated())
+	bob := login(t, addr, "bob")
+	bob.send("/kick bob")
+	bob.expectPrefix("* permission denied")
+}
+
+func TestServer_OperatorKicksUser(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, moderated())
+	op := login(t, addr, "op")
+	bob := login(t, addr, "bob")
+	op.skipUntil("* bob has joined")
+
+	op.send("/kick bob")
+	bob.skipUntil("* you have been kicked by op")
+	bob.expectClosed()
+}
+
+func TestServer_BannedUserCannotJoin(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, moderated())
+	op := login(t, addr, "op")
+	bob := login(t, addr, "bob")
+	op.skipUntil("* bob has joined")
+
+	op.send("/ban bob")
+	bob.skipUntil("* you have been banned by op")
+	bob.expectClosed()
+
+	again := login(t, addr, "bob")
+	again.skipUntil("* you are banned from this server")
+	again.expectClosed()
+}
+
+func TestServer_BannedAddressCannotJoin(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, moderated())
+	op := login(t, addr, "op")
+	op.send("/ban 127.0.0.1")
+	op.skipUntil("* 127.0.0.1 is banned")
+
+	c := login(t, addr, "bob")
+	c.skipUntil("* you are banned from this server")
+	c.expectClosed()
+}
+
+func TestServer_MutedUserCannotPost(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, moderated())
+	op := login(t, addr, "op")
+	bob := login(t, addr, "bob")
+	op.skipUntil("* bob has joined")
+
+	op.send("/mute bob")
+	op.skipUntil("* bob is muted")
+	bob.send("spam")
+	bob.skipUntil("* you are muted")
+
+	op.send("/unmute bob")
+	op.skipUntil("* bob is no longer muted")
+	bob.send("sorry")
+	bob.skipUntil("bob: sorry")
+}
@@ -0,0 +1,130 @@
+package icq
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of messages other than chat messages and system notifications.
+const (
+	// KindPresence announces a change of the sender's presence state
+	// ("online" or "away", optionally followed by a reason) in Text.
+	KindPresence = "presence"
+
+	// KindTyping tells that the sender is typing a message.
+	// It is delivered only to clients speaking the JSON protocol.
+	KindTyping = "typing"
+
+	// KindPing is a heartbeat the client has to answer with "/pong".
+	KindPing = "ping"
+
+	// KindBot marks a chat message posted by a bot. Bots never
+	// receive them, so they can't keep answering each other.
+	KindBot = "bot"
+)
+
+// Presence states.
+const (
+	Online = "online"
+	Away   = "away"
+)
+
+// protocol describes how the server talks to the client.
+type protocol struct {
+	encode encoder
+	typing bool // client receives typing notifications
+}
+
+var (
+	textProtocol = protocol{encode: writeText}
+	jsonProtocol = protocol{encode: writeJSON, typing: true}
+)
+
+// activityReader records the time of the last successful read.
+type activityReader struct {
+	r    io.Reader
+	last atomic.Int64 // unix nano
+}
+
+func newActivityReader(r io.Reader) *activityReader {
+	a := &activityReader{r: r}
+	a.last.Store(time.Now().UnixNano())
+	return a
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (a *activityReader) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, a.last.Load()))
+}
+
+// heartbeat pings the client every HeartbeatInterval and disconnects
+// it when nothing was received from it for HeartbeatTimeout. It runs
+// until the stop channel is closed.
+func (s *Server) heartbeat(cl *client, in *activityReader, stop <-chan struct{}) {
+	timeout := s.HeartbeatTimeout
+	if timeout == 0 {
+		timeout = 3 * s.HeartbeatInterval
+	}
+	ticker := time.NewTicker(s.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if in.idle(now) > timeout {
+				s.Logger.Printf("%s timed out", cl.host)
+				// a writer blocked on the dead connection is cut off too
+				cl.cutOff()
+				return
+			}
+			select {
+			case cl.out <- Message{Time: now, Kind: KindPing}:
+			case <-time.After(timeout):
+				// the writer is stuck on the dead connection
+				s.Logger.Printf("%s timed out", cl.host)
+				cl.cutOff()
+				return
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// setPresence changes the client's presence state and announces
+// it to the room and to linked servers.
+func (s *Server) setPresence(h *hub, cl *client, status string) {
+	cl.status = status
+	m := Message{Time: time.Now(), Room: cl.room, From: cl.who, Kind: KindPresence, Text: status}
+	h.broadcast(m)
+	s.relay(h, relayPresence, m)
+}
+
+// typing notifies the client's room that the client is typing.
+func (s *Server) typing(h *hub, cl *client) {
+	m := Message{Time: time.Now(), Room: cl.room, From: cl.who, Kind: KindTyping}
+	for other := range h.clients {
+		if other != cl && other.room == cl.room && other.proto.typing {
+			other.out <- m
+		}
+	}
+	s.relay(h, relayTyping, m)
+}
+
+// awayStatus returns the presence state set with the /away command.
+func awayStatus(fields []string) string {
+	if len(fields) < 2 {
+		return Away
+	}
+	return Away + ": " + strings.Join(fields[1:], " ")
+}
@@ -0,0 +1,184 @@
+package icq_test
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/icq"
+)
+
+func TestServer_DisconnectsClientsNotAnsweringPings(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{HeartbeatInterval: 20 * time.Millisecond})
+	c := dial(t, addr)
+	c.expectPrefix("/ping")
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for c.input.Scan() {
+		if c.input.Text() != "/ping" {
+			t.Fatalf("unexpected line %q", c.input.Text())
+		}
+	}
+	if err := c.input.Err(); err != nil {
+		t.Errorf("want connection closed by server, got %v", err)
+	}
+}
+
+// pipeListener serves in-memory connections, whose writes
+// block until the other end reads, like a full send buffer.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func newPipeListener(t *testing.T) *pipeListener {
+	l := &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// dial connects a client from the address to the server.
+func (l *pipeListener) dial(t *testing.T, from string) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	addr, err := net.ResolveTCPAddr("tcp", from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l.conns <- addrConn{Conn: server, remote: addr}:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server not accepting")
+	}
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+// addrConn is a connection from the remote address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestServer_CutsOffClientsBlockingWrites(t *testing.T) {
+	t.Parallel()
+
+	l := newPipeListener(t)
+	s := &icq.Server{HeartbeatInterval: 20 * time.Millisecond, Logger: log.New(io.Discard, "", 0)}
+	go s.Serve(l)
+
+	// reads the greeting and then nothing, its writes never finish
+	stuck := bufio.NewScanner(l.dial(t, "10.0.0.1:1000"))
+	if !stuck.Scan() {
+		t.Fatal(stuck.Err())
+	}
+
+	conn := l.dial(t, "10.0.0.2:1000")
+	bob := &testClient{t: t, conn: conn, input: bufio.NewScanner(conn)}
+	bob.expectPrefix("* Connected new client: ")
+	bob.send("hi")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		line := bob.read()
+		if line == "/ping" {
+			bob.send("/pong")
+			continue
+		}
+		if line == "10.0.0.2:1000: hi" {
+			return
+		}
+	}
+	t.Error("want the message broadcast once the stuck client is cut off")
+}
+
+func TestServer_KeepsClientsAnsweringPings(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{HeartbeatInterval: 20 * time.Millisecond})
+	c := dial(t, addr)
+	c.nick("alice")
+	for i := 0; i < 10; i++ {
+		c.expectPrefix("/ping")
+		c.send("/pong")
+	}
+	c.send("still here")
+	c.skipUntil("alice: still here")
+}
+
+func TestServer_AnnouncesPresenceChanges(t *testing.T) {
+	t.Parallel()
+
+	addr := startServer(t, &icq.Server{})
+	alice := dial(t, addr)
+	alice.nick("alice")
+	bob := dial(t, addr)
+	bob.nick("bob")
+
+	alice.send("/away lunch")
+	bob.expectPrefix("* alice is away: lunch")
+	bob.send("/who")
+	bob.expectPrefix("* in lobby: alice (away: lunch), bob")
+
+	alice.send("/back")
+	bob.expectPrefix("* alice is online")
+}
+
+func TestServer_SendsTypingNotificationsToJSONClientsOnly(t *testing.T) {
+	t.Parallel()
+
+	s := &icq.Server{}
+	addr := startServer(t, s)
+	web := httptest.NewServer(s.Handler())
+	t.Cleanup(web.Close)
+
+	browser := dialWS(t, web.URL)
+	browser.read() // greeting
+	alice := dial(t, addr)
+	alice.nick("alice")
+	bob := dial(t, addr)
+	bob.nick("bob")
+
+	alice.send("/typing")
+	alice.send("done typing")
+	bob.expectPrefix("alice: done typing")
+
+	for {
+		m := browser.read()
+		if m.Kind == icq.KindTyping {
+			if m.From != "alice" {
+				t.Errorf("want alice typing, got %+v", m)
+			}
+			return
+		}
+		if m.Text == "done typing" {
+			t.Fatal("typing notification not delivered")
+		}
+	}
+}
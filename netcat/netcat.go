@@ -1,24 +1,197 @@
 package netcat
 
 import (
+	"context"
+	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
+	"time"
 )
 
-func Run() {
-	conn, err := net.Dial("tcp", "localhost:9000")
+// Config of a netcat session. A session connects to, or with
+// Listen waits for a connection from, Addr and then copies
+// Stdin to the connection and the connection to Stdout, both
+// ways at once.
+//
+// When Stdin ends the sending side of a TCP or unix connection
+// is closed, so the peer sees the end of input but can still
+// answer. The session ends when the peer closes the connection.
+type Config struct {
+	// Network is "tcp" (default), "tcp4", "tcp6" or "unix".
+	Network string
+
+	// Addr to connect to, or listen on in Listen mode,
+	// "localhost:9000" if empty.
+	Addr string
+
+	// Listen accepts a single connection on Addr
+	// instead of connecting to it.
+	Listen bool
+
+	// Timeout for establishing the connection,
+	// no timeout if zero.
+	Timeout time.Duration
+
+	// IdleTimeout ends the session if no data is sent
+	// or received for that long, no timeout if zero.
+	IdleTimeout time.Duration
+
+	// Stdin and Stdout of the session, os.Stdin and
+	// os.Stdout if nil.
+	Stdin  io.Reader
+	Stdout io.Writer
+}
+
+func (c *Config) network() string {
+	if c.Network == "" {
+		return "tcp"
+	}
+	return c.Network
+}
+
+func (c *Config) addr() string {
+	if c.Addr == "" {
+		return "localhost:9000"
+	}
+	return c.Addr
+}
+
+// Run runs the session until the peer closes the connection,
+// the connection fails or ctx is cancelled.
+func (c *Config) Run(ctx context.Context) error {
+	conn, err := c.connect(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer conn.Close()
-	go mustCopy(os.Stdout, conn)
-	mustCopy(conn, os.Stdout)
+
+	stdin, stdout := c.Stdin, c.Stdout
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	return Copy(ctx, withIdleTimeout(conn, c.IdleTimeout), stdin, stdout)
+}
+
+// connect dials or accepts the connection.
+func (c *Config) connect(ctx context.Context) (net.Conn, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	if !c.Listen {
+		var d net.Dialer
+		return d.DialContext(ctx, c.network(), c.addr())
+	}
+
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, c.network(), c.addr())
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	return accept(ctx, l)
+}
+
+// accept returns the first connection accepted on l
+// or the context's error if ctx is done first.
+func accept(ctx context.Context, l net.Listener) (net.Conn, error) {
+	type accepted struct {
+		conn net.Conn
+		err  error
+	}
+	result := make(chan accepted, 1)
+	go func() {
+		conn, err := l.Accept()
+		result <- accepted{conn, err}
+	}()
+	select {
+	case a := <-result:
+		return a.conn, a.err
+	case <-ctx.Done():
+		l.Close()
+		if a := <-result; a.conn != nil {
+			a.conn.Close()
+		}
+		return nil, fmt.Errorf("accept on %s: %w", l.Addr(), ctx.Err())
+	}
+}
+
+// Copy copies in to the connection and the connection to out
+// at the same time. When in ends the sending side of conn is
+// closed. Copy returns when the peer closes the connection,
+// copying fails or ctx is cancelled.
+func Copy(ctx context.Context, conn net.Conn, in io.Reader, out io.Writer) error {
+	sent := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, in)
+		if err == nil {
+			err = closeWrite(conn)
+		}
+		sent <- err
+	}()
+	received := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, conn)
+		received <- err
+	}()
+
+	for {
+		select {
+		case err := <-sent:
+			if err != nil {
+				return err
+			}
+			sent = nil // keep receiving the answer
+		case err := <-received:
+			return err
+		case <-ctx.Done():
+			conn.Close()
+			<-received
+			return ctx.Err()
+		}
+	}
+}
+
+// closeWrite closes the sending side of the connection
+// if it supports half-close and the whole connection if not.
+func closeWrite(conn net.Conn) error {
+	if ic, ok := conn.(*idleConn); ok {
+		conn = ic.Conn
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return conn.Close()
+}
+
+// idleConn extends the connection's deadline on every read and write.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func withIdleTimeout(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+	return &idleConn{Conn: conn, timeout: timeout}
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
 }
 
-func mustCopy(dst io.Writer, src io.Reader) {
-	if _, err := io.Copy(dst, src); err != nil {
-		log.Fatal(err)
+func (c *idleConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
 	}
+	return c.Conn.Write(p)
 }
@@ -0,0 +1,159 @@
+package netcat_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/netcat"
+)
+
+func TestRun_HalfClosesAndReadsAnswerAfterInputEnds(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// answers only after the client stops sending
+		data, _ := io.ReadAll(conn)
+		io.WriteString(conn, strings.ToUpper(string(data)))
+	}()
+
+	var out bytes.Buffer
+	c := netcat.Config{Addr: l.Addr().String(), Stdin: strings.NewReader("hello\nworld\n"), Stdout: &out}
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "HELLO\nWORLD\n", out.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestRun_ListensForConnection(t *testing.T) {
+	t.Parallel()
+
+	addr := filepath.Join(t.TempDir(), "nc.sock")
+	var out bytes.Buffer
+	c := netcat.Config{Network: "unix", Addr: addr, Listen: true, Timeout: 2 * time.Second, Stdin: strings.NewReader("pong\n"), Stdout: &out}
+	done := make(chan error)
+	go func() { done <- c.Run(context.Background()) }()
+
+	var conn net.Conn
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var err error
+		if conn, err = net.Dial("unix", addr); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	io.WriteString(conn, "ping\n")
+	answer, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "ping\n", out.String(); want != got {
+		t.Errorf("want %q received, got %q", want, got)
+	}
+	if want, got := "pong\n", answer; want != got {
+		t.Errorf("want %q sent, got %q", want, got)
+	}
+}
+
+func TestRun_FailsWhenNobodyConnectsInTime(t *testing.T) {
+	t.Parallel()
+
+	c := netcat.Config{Network: "unix", Addr: filepath.Join(t.TempDir(), "nc.sock"), Listen: true, Timeout: 50 * time.Millisecond}
+	err := c.Run(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want deadline exceeded, got %v", err)
+	}
+}
+
+func TestRun_FailsWhenConnectionIsRefused(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	c := netcat.Config{Addr: l.Addr().String(), Stdin: strings.NewReader(""), Stdout: io.Discard}
+	if err := c.Run(context.Background()); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestRun_EndsIdleSession(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn) // never answers
+	}()
+
+	in, _ := io.Pipe() // never ends
+	c := netcat.Config{Addr: l.Addr().String(), IdleTimeout: 50 * time.Millisecond, Stdin: in, Stdout: io.Discard}
+	err = c.Run(context.Background())
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("want timeout, got %v", err)
+	}
+}
+
+func TestRun_StopsWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	in, _ := io.Pipe()
+	c := netcat.Config{Addr: l.Addr().String(), Stdin: in, Stdout: io.Discard}
+	if err := c.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context error, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package netcat
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Relay listens on Addr and forwards every accepted connection
+// to Target, so traffic between a client and a server can be
+// watched. Both ways are copied at once and the end of input
+// in one direction is passed on as a half-close.
+type Relay struct {
+	// Network is "tcp" (default), "tcp4", "tcp6" or "unix".
+	Network string
+
+	// Addr to listen on, "localhost:9000" if empty.
+	Addr string
+
+	// TargetNetwork is the network of Target, Network if empty.
+	TargetNetwork string
+
+	// Target address connections are forwarded to.
+	Target string
+
+	// Timeout for connecting to Target, no timeout if zero.
+	Timeout time.Duration
+
+	// Dump receives a hex dump of all forwarded traffic, nothing
+	// is dumped if nil. Writes from all connections are serialized.
+	Dump io.Writer
+
+	Logger *log.Logger
+
+	mu    sync.Mutex
+	conns int
+}
+
+// Run listens on Addr and serves connections until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) error {
+	c := Config{Network: r.Network, Addr: r.Addr}
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, c.network(), c.addr())
+	if err != nil {
+		return err
+	}
+	return r.Serve(ctx, l)
+}
+
+// Serve forwards connections accepted on l until ctx is
+// cancelled. It closes l and waits for the forwarded
+// connections to end before returning.
+func (r *Relay) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.forward(ctx, conn)
+		}()
+	}
+}
+
+// forward copies traffic between the client and the target.
+func (r *Relay) forward(ctx context.Context, client net.Conn) {
+	defer client.Close()
+	r.mu.Lock()
+	r.conns++
+	id := r.conns
+	r.mu.Unlock()
+
+	network := r.TargetNetwork
+	if network == "" {
+		network = (&Config{Network: r.Network}).network()
+	}
+	dialCtx := ctx
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	target, err := d.DialContext(dialCtx, network, r.Target)
+	if err != nil {
+		r.logf("conn %d: %v", id, err)
+		return
+	}
+	defer target.Close()
+	r.logf("conn %d: %s > %s", id, client.RemoteAddr(), r.Target)
+
+	in := io.Reader(client)
+	out := io.Writer(client)
+	if r.Dump != nil {
+		in = &dumpReader{r: client, relay: r, id: id}
+		out = &dumpWriter{w: client, relay: r, id: id}
+	}
+	// each way is copied until its sender is done and then
+	// half-closed, the connections are closed once both are
+	errs := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst net.Conn, w io.Writer, src io.Reader) {
+		defer wg.Done()
+		_, err := io.Copy(w, src)
+		if err == nil {
+			err = closeWrite(dst)
+		}
+		if err != nil {
+			// the other way can't go on without this one
+			client.Close()
+			target.Close()
+		}
+		errs <- err
+	}
+	go pipe(target, target, in)
+	go pipe(client, out, target)
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+			target.Close()
+		case <-stop:
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	close(errs)
+
+	for err := range errs {
+		if err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+			r.logf("conn %d: %v", id, err)
+		}
+	}
+	r.logf("conn %d: closed", id)
+}
+
+// dump writes a hex dump of data sent in the direction.
+func (r *Relay) dump(id int, direction string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.Dump, "conn %d %s %d bytes\n%s", id, direction, len(data), hex.Dump(data))
+}
+
+func (r *Relay) logf(format string, v ...any) {
+	if r.Logger != nil {
+		r.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// dumpReader dumps data the client sends to the target.
+type dumpReader struct {
+	r     io.Reader
+	relay *Relay
+	id    int
+}
+
+func (d *dumpReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if n > 0 {
+		d.relay.dump(d.id, "client > target", p[:n])
+	}
+	return n, err
+}
+
+// dumpWriter dumps data the target sends to the client.
+type dumpWriter struct {
+	w     io.Writer
+	relay *Relay
+	id    int
+}
+
+func (d *dumpWriter) Write(p []byte) (int, error) {
+	d.relay.dump(d.id, "client < target", p)
+	return d.w.Write(p)
+}
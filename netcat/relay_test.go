@@ -0,0 +1,169 @@
+package netcat_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/netcat"
+)
+
+func TestRelay_ForwardsConnectionsAndDumpsTraffic(t *testing.T) {
+	t.Parallel()
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// answers only after the client stops sending
+				data, _ := io.ReadAll(conn)
+				io.WriteString(conn, strings.ToUpper(string(data)))
+			}()
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := &syncBuffer{}
+	r := netcat.Relay{Target: target.Addr().String(), Dump: dump, Logger: log.New(io.Discard, "", 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- r.Serve(ctx, l) }()
+
+	var wg sync.WaitGroup
+	for _, shout := range []string{"hello", "world", "gopher"} {
+		shout := shout
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			io.WriteString(conn, shout)
+			conn.(*net.TCPConn).CloseWrite()
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Error(err)
+			}
+			if want := strings.ToUpper(shout); want != string(got) {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("want relay stopped cleanly, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay still running after cancel")
+	}
+
+	got := dump.String()
+	for _, want := range []string{
+		"client > target 5 bytes\n00000000  68 65 6c 6c 6f",
+		"client < target 5 bytes\n00000000  48 45 4c 4c 4f",
+		"client > target 6 bytes\n00000000  67 6f 70 68 65 72",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want dump containing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRelay_KeepsForwardingAfterTargetHalfCloses(t *testing.T) {
+	t.Parallel()
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// greets and stops sending, but still listens
+		io.WriteString(conn, "hello")
+		conn.(*net.TCPConn).CloseWrite()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := netcat.Relay{Target: target.Addr().String(), Logger: log.New(io.Discard, "", 0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Serve(ctx, l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; want != string(got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	io.WriteString(conn, "goodbye")
+	conn.(*net.TCPConn).CloseWrite()
+	select {
+	case got := <-received:
+		if want := "goodbye"; want != got {
+			t.Errorf("want target to receive %q, got %q", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("target received nothing")
+	}
+}
+
+// syncBuffer is a buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
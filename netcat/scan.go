@@ -0,0 +1,184 @@
+package netcat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// State of a scanned port.
+type State int
+
+const (
+	// Closed ports refuse connections.
+	Closed State = iota
+
+	// Open ports accept connections.
+	Open
+
+	// Filtered ports don't answer in time or can't be reached,
+	// usually because a firewall drops the probes.
+	Filtered
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case Closed:
+		return "closed"
+	case Filtered:
+		return "filtered"
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Result of probing a port.
+type Result struct {
+	Port  int
+	State State
+
+	// Err is the error the probe of a closed
+	// or filtered port failed with.
+	Err error
+}
+
+// Scanner probes ports of a host by connecting to them.
+type Scanner struct {
+	// Network is "tcp" (default), "tcp4" or "tcp6".
+	Network string
+
+	// Host to scan, "localhost" if empty.
+	Host string
+
+	// Workers is a number of ports probed at once, 100 if zero.
+	Workers int
+
+	// Timeout of a single probe, 1s if zero. Ports which
+	// don't answer in time are reported as Filtered.
+	Timeout time.Duration
+}
+
+// Scan probes the ports concurrently and returns
+// the results ordered by port.
+func (s *Scanner) Scan(ctx context.Context, ports []int) ([]Result, error) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 100
+	}
+	if workers > len(ports) {
+		workers = len(ports)
+	}
+
+	jobs := make(chan int)
+	results := make(chan Result)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for port := range jobs {
+				results <- s.probe(ctx, port)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	go func() {
+		defer close(jobs)
+		for _, port := range ports {
+			select {
+			case jobs <- port:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var scanned []Result
+	for len(scanned) < len(ports) {
+		select {
+		case r := <-results:
+			scanned = append(scanned, r)
+		case <-ctx.Done():
+			// let the workers finish their probes
+			go func() {
+				for range results {
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+	sort.Slice(scanned, func(i, j int) bool { return scanned[i].Port < scanned[j].Port })
+	return scanned, nil
+}
+
+// probe connects to the port and tells its state.
+func (s *Scanner) probe(ctx context.Context, port int) Result {
+	network, host, timeout := s.Network, s.Host, s.Timeout
+	if network == "" {
+		network = "tcp"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return Result{Port: port, State: Closed, Err: err}
+		}
+		return Result{Port: port, State: Filtered, Err: err}
+	}
+	conn.Close()
+	return Result{Port: port, State: Open}
+}
+
+// ParsePorts parses a comma separated list of ports
+// and port ranges, e.g. "22,80,8000-8080".
+func ParsePorts(s string) ([]int, error) {
+	var ports []int
+	for _, field := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(field), "-")
+		first, err := parsePort(from)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePort(to); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid port range %q", field)
+			}
+		}
+		for p := first; p <= last; p++ {
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
@@ -0,0 +1,102 @@
+package netcat_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/netcat"
+)
+
+func TestScanner_ReportsOpenAndClosedPorts(t *testing.T) {
+	t.Parallel()
+
+	var open, closed []int
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		open = append(open, l.Addr().(*net.TCPAddr).Port)
+	}
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed = append(closed, l.Addr().(*net.TCPAddr).Port)
+		l.Close()
+	}
+
+	s := netcat.Scanner{Host: "127.0.0.1", Workers: 2, Timeout: time.Second}
+	results, err := s.Scan(context.Background(), append(closed, open...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int]netcat.State)
+	for i, r := range results {
+		if i > 0 && results[i-1].Port > r.Port {
+			t.Errorf("want results ordered by port, got %d after %d", r.Port, results[i-1].Port)
+		}
+		got[r.Port] = r.State
+	}
+	want := make(map[int]netcat.State)
+	for _, p := range open {
+		want[p] = netcat.Open
+	}
+	for _, p := range closed {
+		want[p] = netcat.Closed
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestScanner_StopsWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := netcat.Scanner{Host: "127.0.0.1"}
+	_, err := s.Scan(ctx, []int{1, 2, 3})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		in   string
+		want []int
+	}{
+		{in: "80", want: []int{80}},
+		{in: "22,80,443", want: []int{22, 80, 443}},
+		{in: "8000-8003", want: []int{8000, 8001, 8002, 8003}},
+		{in: "22, 9000-9001", want: []int{22, 9000, 9001}},
+	}
+	for _, tc := range tcs {
+		got, err := netcat.ParsePorts(tc.in)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if !cmp.Equal(tc.want, got) {
+			t.Errorf("%q: %s", tc.in, cmp.Diff(tc.want, got))
+		}
+	}
+}
+
+func TestParsePorts_RejectsInvalidPorts(t *testing.T) {
+	t.Parallel()
+
+	for _, in := range []string{"", "http", "0", "65536", "90-80", "1-", "1,,2"} {
+		if _, err := netcat.ParsePorts(in); err == nil {
+			t.Errorf("%q: want error", in)
+		}
+	}
+}
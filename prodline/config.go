@@ -0,0 +1,337 @@
+package prodline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bakery configuration files are JSON objects or flat YAML
+// mappings with the keys listed in configFields. Durations
+// are written as Go durations, e.g. "2s" or "1m30s":
+//
+//	cakes: 100
+//	bake_time: 2s
+//	bake_stddev: 500ms
+//	icers: 2
+//	...
+//	ice_defect_rate: 0.05
+//	ice_rework: true
+//	packing_mtbf: 10m
+//	packing_repair_time: 1m
+
+// configField maps a configuration key to a Bakery field.
+type configField struct {
+	key  string
+	num  func(b *Bakery) *int
+	dur  func(b *Bakery) *time.Duration
+	flag func(b *Bakery) *bool
+	rate func(b *Bakery) *float64
+}
+
+var configFields = []configField{
+	{key: "verbose", flag: func(b *Bakery) *bool { return &b.Verbose }},
+	{key: "cakes", num: func(b *Bakery) *int { return &b.Cakes }},
+	{key: "bake_time", dur: func(b *Bakery) *time.Duration { return &b.BakeTime }},
+	{key: "bake_stddev", dur: func(b *Bakery) *time.Duration { return &b.BakeStdDev }},
+	{key: "bake_buf", num: func(b *Bakery) *int { return &b.BakeBuf }},
+	{key: "icers", num: func(b *Bakery) *int { return &b.NumIcers }},
+	{key: "ice_time", dur: func(b *Bakery) *time.Duration { return &b.IceTime }},
+	{key: "ice_stddev", dur: func(b *Bakery) *time.Duration { return &b.IceStdDev }},
+	{key: "ice_buf", num: func(b *Bakery) *int { return &b.IceBuf }},
+	{key: "inscribers", num: func(b *Bakery) *int { return &b.NumInscribers }},
+	{key: "inscribe_time", dur: func(b *Bakery) *time.Duration { return &b.InscribeTime }},
+	{key: "inscribe_stddev", dur: func(b *Bakery) *time.Duration { return &b.InscribeStdDev }},
+	{key: "inscribe_buf", num: func(b *Bakery) *int { return &b.InscribeBuf }},
+	{key: "packers", num: func(b *Bakery) *int { return &b.NumPackers }},
+	{key: "packing_time", dur: func(b *Bakery) *time.Duration { return &b.PackingTime }},
+	{key: "packing_stddev", dur: func(b *Bakery) *time.Duration { return &b.PackingStdDev }},
+	{key: "packer_buf", num: func(b *Bakery) *int { return &b.PackerBuf }},
+	{key: "sample_interval", dur: func(b *Bakery) *time.Duration { return &b.SampleInterval }},
+	{key: "bake_defect_rate", rate: func(b *Bakery) *float64 { return &b.BakeFaults.DefectRate }},
+	{key: "bake_rework", flag: func(b *Bakery) *bool { return &b.BakeFaults.Rework }},
+	{key: "bake_mtbf", dur: func(b *Bakery) *time.Duration { return &b.BakeFaults.MTBF }},
+	{key: "bake_repair_time", dur: func(b *Bakery) *time.Duration { return &b.BakeFaults.RepairTime }},
+	{key: "ice_defect_rate", rate: func(b *Bakery) *float64 { return &b.IceFaults.DefectRate }},
+	{key: "ice_rework", flag: func(b *Bakery) *bool { return &b.IceFaults.Rework }},
+	{key: "ice_mtbf", dur: func(b *Bakery) *time.Duration { return &b.IceFaults.MTBF }},
+	{key: "ice_repair_time", dur: func(b *Bakery) *time.Duration { return &b.IceFaults.RepairTime }},
+	{key: "inscribe_defect_rate", rate: func(b *Bakery) *float64 { return &b.InscribeFaults.DefectRate }},
+	{key: "inscribe_rework", flag: func(b *Bakery) *bool { return &b.InscribeFaults.Rework }},
+	{key: "inscribe_mtbf", dur: func(b *Bakery) *time.Duration { return &b.InscribeFaults.MTBF }},
+	{key: "inscribe_repair_time", dur: func(b *Bakery) *time.Duration { return &b.InscribeFaults.RepairTime }},
+	{key: "packing_defect_rate", rate: func(b *Bakery) *float64 { return &b.PackingFaults.DefectRate }},
+	{key: "packing_rework", flag: func(b *Bakery) *bool { return &b.PackingFaults.Rework }},
+	{key: "packing_mtbf", dur: func(b *Bakery) *time.Duration { return &b.PackingFaults.MTBF }},
+	{key: "packing_repair_time", dur: func(b *Bakery) *time.Duration { return &b.PackingFaults.RepairTime }},
+}
+
+// faultKeys prefix the keys of the stages' faults, by stage.
+var faultKeys = []string{"bake", "ice", "inscribe", "packing"}
+
+func lookupField(key string) (configField, bool) {
+	for _, f := range configFields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return configField{}, false
+}
+
+// Config formats.
+const (
+	JSON = "json"
+	YAML = "yaml"
+)
+
+// FormatOf returns the config format of the file, by its extension.
+func FormatOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	}
+	return "", fmt.Errorf("%s: unknown config format, want .json, .yaml or .yml", path)
+}
+
+// LoadConfig reads a bakery configuration from the JSON
+// or YAML file and validates it.
+func LoadConfig(path string) (Bakery, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return Bakery{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Bakery{}, err
+	}
+	defer f.Close()
+	b, err := ParseConfig(f, format)
+	if err != nil {
+		return Bakery{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// ParseConfig reads a bakery configuration in the format
+// and validates it. Keys missing from the configuration
+// are left zero.
+func ParseConfig(r io.Reader, format string) (Bakery, error) {
+	var values map[string]string
+	var err error
+	switch format {
+	case JSON:
+		values, err = parseJSON(r)
+	case YAML:
+		values, err = parseYAML(r)
+	default:
+		err = fmt.Errorf("unknown config format %q", format)
+	}
+	if err != nil {
+		return Bakery{}, err
+	}
+
+	var b Bakery
+	for key, value := range values {
+		f, ok := lookupField(key)
+		if !ok {
+			return Bakery{}, fmt.Errorf("unknown key %q", key)
+		}
+		if err := f.set(&b, value); err != nil {
+			return Bakery{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if err := b.Validate(); err != nil {
+		return Bakery{}, err
+	}
+	return b, nil
+}
+
+// set parses the value into the bakery's field.
+func (f configField) set(b *Bakery, value string) error {
+	switch {
+	case f.num != nil:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("want a whole number, got %q", value)
+		}
+		*f.num(b) = n
+	case f.dur != nil:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("want a duration like 2s, got %q", value)
+		}
+		*f.dur(b) = d
+	case f.flag != nil:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("want true or false, got %q", value)
+		}
+		*f.flag(b) = v
+	case f.rate != nil:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("want a number like 0.05, got %q", value)
+		}
+		*f.rate(b) = v
+	}
+	return nil
+}
+
+// get formats the bakery's field.
+func (f configField) get(b *Bakery) string {
+	switch {
+	case f.num != nil:
+		return strconv.Itoa(*f.num(b))
+	case f.dur != nil:
+		return f.dur(b).String()
+	case f.rate != nil:
+		return strconv.FormatFloat(*f.rate(b), 'g', -1, 64)
+	}
+	return strconv.FormatBool(*f.flag(b))
+}
+
+// parseJSON reads a JSON object with scalar values.
+func parseJSON(r io.Reader) (map[string]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s: want a number, duration or boolean, got %v", key, v)
+		}
+	}
+	return values, nil
+}
+
+// parseYAML reads a flat YAML mapping of scalar values, the only
+// YAML a bakery configuration needs: "key: value" lines, comments
+// and blank lines.
+func parseYAML(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	input := bufio.NewScanner(r)
+	for n := 1; input.Scan(); n++ {
+		line := input.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") || line == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", n)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key: value, got %q", n, line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n, key)
+		}
+		values[key] = value
+	}
+	return values, input.Err()
+}
+
+// maxBreakdowns limits the breakdowns expected while making a cake.
+const maxBreakdowns = 100
+
+// Validate reports all problems with the bakery's configuration.
+func (b Bakery) Validate() error {
+	var errs []error
+	if b.Cakes < 0 {
+		errs = append(errs, fmt.Errorf("cakes: want at least 0, got %d", b.Cakes))
+	}
+	for _, f := range configFields {
+		switch {
+		case f.dur != nil && *f.dur(&b) < 0:
+			errs = append(errs, fmt.Errorf("%s: want a non-negative duration, got %v", f.key, *f.dur(&b)))
+		case f.num != nil && strings.HasSuffix(f.key, "_buf") && *f.num(&b) < 0:
+			errs = append(errs, fmt.Errorf("%s: want at least 0, got %d", f.key, *f.num(&b)))
+		case f.rate != nil && (*f.rate(&b) < 0 || *f.rate(&b) > 1):
+			errs = append(errs, fmt.Errorf("%s: want a probability from 0 to 1, got %v", f.key, *f.rate(&b)))
+		}
+	}
+	for stage, prefix := range faultKeys {
+		f := b.faults(stage)
+		if f.Rework && f.DefectRate >= 1 {
+			errs = append(errs, fmt.Errorf("%s_rework: every cake is defective and would be reworked forever", prefix))
+		}
+		// breaking down many times a cake, the machine would never be up
+		if d, _ := b.workTime(stage); f.MTBF > 0 && f.MTBF < d/maxBreakdowns {
+			errs = append(errs, fmt.Errorf("%s_mtbf: want at least %v, a %dth of the work time, got %v", prefix, d/maxBreakdowns, maxBreakdowns, f.MTBF))
+		}
+	}
+	for _, w := range []struct {
+		key string
+		n   int
+	}{{"icers", b.NumIcers}, {"inscribers", b.NumInscribers}, {"packers", b.NumPackers}} {
+		if w.n < 1 {
+			errs = append(errs, fmt.Errorf("%s: want at least 1 worker, got %d", w.key, w.n))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteConfig writes the bakery's configuration in the format,
+// with all the keys, so it can be loaded back.
+func WriteConfig(w io.Writer, b Bakery, format string) error {
+	var buf bytes.Buffer
+	switch format {
+	case YAML:
+		for _, f := range configFields {
+			fmt.Fprintf(&buf, "%s: %s\n", f.key, f.get(&b))
+		}
+	case JSON:
+		buf.WriteString("{\n")
+		for i, f := range configFields {
+			var value any = f.get(&b)
+			switch {
+			case f.num != nil:
+				value = *f.num(&b)
+			case f.flag != nil:
+				value = *f.flag(&b)
+			case f.rate != nil:
+				value = *f.rate(&b)
+			}
+			v, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			sep := ","
+			if i == len(configFields)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(&buf, "  %q: %s%s\n", f.key, v, sep)
+		}
+		buf.WriteString("}\n")
+	default:
+		return fmt.Errorf("unknown config format %q", format)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,182 @@
+package prodline_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/prodline"
+)
+
+var configured = prodline.Bakery{
+	Cakes:          20,
+	BakeTime:       time.Minute,
+	BakeStdDev:     10 * time.Second,
+	BakeBuf:        1,
+	NumIcers:       2,
+	IceTime:        3 * time.Minute,
+	NumInscribers:  1,
+	InscribeTime:   time.Minute,
+	NumPackers:     1,
+	PackingTime:    10 * time.Second,
+	SampleInterval: time.Minute,
+	IceFaults:      prodline.Faults{DefectRate: 0.05, Rework: true},
+	PackingFaults:  prodline.Faults{MTBF: 10 * time.Minute, RepairTime: time.Minute},
+}
+
+func TestParseConfig_ReadsYAML(t *testing.T) {
+	t.Parallel()
+
+	in := `---
+# icing is the slowest
+cakes: 20
+bake_time: 1m
+bake_stddev: 10s
+bake_buf: 1
+icers: 2
+ice_time: 3m # three times baking
+inscribers: 1
+inscribe_time: "1m"
+packers: 1
+packing_time: '10s'
+ice_defect_rate: 0.05
+ice_rework: true
+packing_mtbf: 10m
+packing_repair_time: 1m
+
+sample_interval: 1m
+`
+	got, err := prodline.ParseConfig(strings.NewReader(in), prodline.YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(configured, got) {
+		t.Error(cmp.Diff(configured, got))
+	}
+}
+
+func TestParseConfig_ReadsJSON(t *testing.T) {
+	t.Parallel()
+
+	in := `{
+		"cakes": 20,
+		"bake_time": "1m", "bake_stddev": "10s", "bake_buf": 1,
+		"icers": 2, "ice_time": "3m",
+		"inscribers": 1, "inscribe_time": "1m",
+		"packers": 1, "packing_time": "10s",
+		"ice_defect_rate": 0.05, "ice_rework": true,
+		"packing_mtbf": "10m", "packing_repair_time": "1m",
+		"sample_interval": "1m",
+		"verbose": false
+	}`
+	got, err := prodline.ParseConfig(strings.NewReader(in), prodline.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(configured, got) {
+		t.Error(cmp.Diff(configured, got))
+	}
+}
+
+func TestWriteConfig_WritesConfigLoadingBack(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{prodline.YAML, prodline.JSON} {
+		var buf bytes.Buffer
+		if err := prodline.WriteConfig(&buf, configured, format); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "bakery."+format)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := prodline.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, buf.String())
+		}
+		if !cmp.Equal(configured, got) {
+			t.Errorf("%s: %s", format, cmp.Diff(configured, got))
+		}
+	}
+}
+
+func TestParseConfig_RejectsInvalidConfigs(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name, format, in, want string
+	}{
+		{
+			name:   "no workers",
+			format: prodline.YAML,
+			in:     "cakes: 1\nicers: 1\ninscribers: 0\npackers: 1\n",
+			want:   "inscribers: want at least 1 worker, got 0",
+		},
+		{
+			name:   "negative duration",
+			format: prodline.JSON,
+			in:     `{"icers": 1, "inscribers": 1, "packers": 1, "ice_time": "-3s"}`,
+			want:   "ice_time: want a non-negative duration, got -3s",
+		},
+		{
+			name:   "negative buffer",
+			format: prodline.YAML,
+			in:     "icers: 1\ninscribers: 1\npackers: 1\npacker_buf: -1\n",
+			want:   "packer_buf: want at least 0, got -1",
+		},
+		{
+			name:   "defect rate above 1",
+			format: prodline.YAML,
+			in:     "icers: 1\ninscribers: 1\npackers: 1\nbake_defect_rate: 1.5\n",
+			want:   "bake_defect_rate: want a probability from 0 to 1, got 1.5",
+		},
+		{
+			name:   "rework forever",
+			format: prodline.JSON,
+			in:     `{"icers": 1, "inscribers": 1, "packers": 1, "ice_defect_rate": 1, "ice_rework": true}`,
+			want:   "ice_rework: every cake is defective and would be reworked forever",
+		},
+		{
+			name:   "breakdowns all the time",
+			format: prodline.YAML,
+			in:     "icers: 1\ninscribers: 1\npackers: 1\npacking_time: 1s\npacking_mtbf: 1ms\n",
+			want:   "packing_mtbf: want at least 10ms, a 100th of the work time, got 1ms",
+		},
+		{
+			name:   "unknown key",
+			format: prodline.YAML,
+			in:     "ovens: 2\n",
+			want:   `unknown key "ovens"`,
+		},
+		{
+			name:   "duration without unit",
+			format: prodline.JSON,
+			in:     `{"bake_time": 60}`,
+			want:   `bake_time: want a duration like 2s, got "60"`,
+		},
+		{
+			name:   "nested yaml",
+			format: prodline.YAML,
+			in:     "icing:\n  workers: 2\n",
+			want:   "line 2: nested values are not supported",
+		},
+	}
+	for _, tc := range tcs {
+		_, err := prodline.ParseConfig(strings.NewReader(tc.in), tc.format)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: want error %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestLoadConfig_RejectsUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	if _, err := prodline.LoadConfig("bakery.toml"); err == nil {
+		t.Error("want error")
+	}
+}
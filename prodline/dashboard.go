@@ -0,0 +1,213 @@
+package prodline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dashboard shows a live view of the bakery's line, refreshed
+// every Interval, from the events of its runs:
+//
+//	run 1 running, 12.3s: 5 of 30 cakes packed, 0.41 cakes/s
+//
+//	baking      [W]     busy 1  blocked 0  idle 0  down 0      7 done  0.57 cakes/s
+//	  baked     [##  ]  2/4
+//	icing       [WB]    busy 1  blocked 1  idle 0  down 0      5 done  0.41 cakes/s
+//	...
+//
+// Pass its Observe method as the bakery's Events.
+type Dashboard struct {
+	// Bakery whose line is shown. The workers shown are
+	// the ones the runs start with, the Bakery's if the
+	// RunStarted event doesn't tell.
+	Bakery Bakery
+
+	// Output the dashboard is drawn on, an ANSI
+	// terminal, os.Stdout if nil.
+	Output io.Writer
+
+	// Interval between refreshes, 250ms if zero.
+	Interval time.Duration
+
+	mu        sync.Mutex
+	run       int
+	at        time.Duration
+	finished  bool
+	workers   [][]EventKind // state of each worker
+	done      []int
+	discarded []int
+	buffers   []int
+}
+
+// Observe updates the dashboard with the event.
+// It is safe for concurrent use.
+func (d *Dashboard) Observe(e Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e.Kind == RunStarted || d.workers == nil {
+		d.reset(e.Workers)
+		if e.Kind == RunStarted {
+			d.run++
+		}
+	}
+	if e.At > d.at {
+		d.at = e.At
+	}
+	if e.Stage < 0 || e.Stage >= len(d.workers) {
+		return // not a stage of the line shown
+	}
+	switch e.Kind {
+	case RunFinished:
+		d.finished = true
+	case WorkerWorking, WorkerBlocked, WorkerIdle, WorkerDown:
+		d.setWorker(e.Stage, e.Worker, e.Kind)
+	case CakeDone:
+		d.done[e.Stage]++
+	case CakeDiscarded:
+		d.discarded[e.Stage]++
+		d.setWorker(e.Stage, e.Worker, WorkerIdle)
+	case BufferSampled:
+		d.buffers[e.Stage] = e.Len
+	}
+}
+
+// setWorker records the state of the worker, if the line has it.
+func (d *Dashboard) setWorker(stage, worker int, state EventKind) {
+	if worker >= 0 && worker < len(d.workers[stage]) {
+		d.workers[stage][worker] = state
+	}
+}
+
+// reset clears the dashboard for a new run with the numbers
+// of workers of the stages, the Bakery's if nil.
+func (d *Dashboard) reset(counts []int) {
+	if len(counts) != len(stageNames) {
+		b := &d.Bakery
+		counts = []int{1, b.NumIcers, b.NumInscribers, b.NumPackers}
+	}
+	d.at, d.finished = 0, false
+	d.workers = make([][]EventKind, len(counts))
+	for i, n := range counts {
+		if n < 0 {
+			n = 0
+		}
+		d.workers[i] = make([]EventKind, n)
+		for j := range d.workers[i] {
+			d.workers[i][j] = WorkerIdle
+		}
+	}
+	d.done = make([]int, len(counts))
+	d.discarded = make([]int, len(counts))
+	d.buffers = make([]int, len(counts))
+}
+
+// Run redraws the dashboard until ctx is cancelled,
+// and then draws it for the last time.
+func (d *Dashboard) Run(ctx context.Context) error {
+	out := d.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	interval := d.Interval
+	if interval == 0 {
+		interval = 250 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := io.WriteString(out, d.render()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			_, err := io.WriteString(out, d.render())
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// workerGlyphs show the workers' states.
+var workerGlyphs = map[EventKind]byte{
+	WorkerWorking: 'W',
+	WorkerBlocked: 'B',
+	WorkerIdle:    '.',
+	WorkerDown:    'D',
+}
+
+// render draws the dashboard, clearing the terminal first.
+func (d *Dashboard) render() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	if d.workers == nil {
+		b.WriteString("waiting for the first run\n")
+		return b.String()
+	}
+
+	packed, discarded := d.done[packing], 0
+	for _, n := range d.discarded {
+		discarded += n
+	}
+	status := "running"
+	if d.finished {
+		status = "finished"
+	}
+	fmt.Fprintf(&b, "run %d %s, %v: %d of %d cakes packed", d.run, status, d.at.Round(100*time.Millisecond), packed, d.Bakery.Cakes)
+	if discarded > 0 {
+		fmt.Fprintf(&b, ", %d discarded", discarded)
+	}
+	fmt.Fprintf(&b, ", %.2f cakes/s\n\n", d.rate(packed))
+
+	capacities := []int{d.Bakery.BakeBuf, d.Bakery.IceBuf, d.Bakery.InscribeBuf, d.Bakery.PackerBuf}
+	for stage, workers := range d.workers {
+		glyphs := make([]byte, len(workers))
+		counts := make(map[EventKind]int)
+		for i, state := range workers {
+			glyphs[i] = workerGlyphs[state]
+			counts[state]++
+		}
+		fmt.Fprintf(&b, "%-10s  %-7s busy %d  blocked %d  idle %d  down %d  %5d done  %.2f cakes/s\n",
+			stageNames[stage], "["+string(glyphs)+"]",
+			counts[WorkerWorking], counts[WorkerBlocked], counts[WorkerIdle], counts[WorkerDown],
+			d.done[stage], d.rate(d.done[stage]))
+		if d.discarded[stage] > 0 {
+			fmt.Fprintf(&b, "  %d defective cakes discarded\n", d.discarded[stage])
+		}
+		fmt.Fprintf(&b, "  %-10s%s\n", bufferNames[stage], fill(d.buffers[stage], capacities[stage]))
+	}
+	b.WriteString("\nW working  B blocked  . idle  D down\n")
+	return b.String()
+}
+
+// rate returns the number of cakes per second of the run so far.
+func (d *Dashboard) rate(cakes int) float64 {
+	if d.at <= 0 {
+		return 0
+	}
+	return float64(cakes) / d.at.Seconds()
+}
+
+// fill draws the fill level of a buffer, scaled down
+// to fit in 20 characters.
+func fill(n, capacity int) string {
+	if capacity == 0 {
+		return "unbuffered"
+	}
+	width := capacity
+	if width > 20 {
+		width = 20
+	}
+	full := n * width / capacity
+	if full > width {
+		full = width
+	}
+	return fmt.Sprintf("[%s%s]  %d/%d", strings.Repeat("#", full), strings.Repeat(" ", width-full), n, capacity)
+}
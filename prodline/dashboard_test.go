@@ -0,0 +1,157 @@
+package prodline_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/prodline"
+)
+
+// lastFrame runs the dashboard until it draws the last
+// time and returns what it drew.
+func lastFrame(t *testing.T, d *prodline.Dashboard) string {
+	t.Helper()
+	var buf bytes.Buffer
+	d.Output = &buf
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	frames := strings.Split(buf.String(), "\x1b[H\x1b[2J")
+	return frames[len(frames)-1]
+}
+
+func TestDashboard_ShowsStagesFromEvents(t *testing.T) {
+	t.Parallel()
+
+	d := &prodline.Dashboard{Bakery: prodline.Bakery{
+		Cakes:         30,
+		BakeBuf:       4,
+		NumIcers:      2,
+		NumInscribers: 1,
+		NumPackers:    1,
+	}}
+	for _, e := range []prodline.Event{
+		{Kind: prodline.RunStarted},
+		{Kind: prodline.WorkerWorking, Stage: 0},
+		{Kind: prodline.CakeDone, Stage: 0},
+		{Kind: prodline.WorkerWorking, Stage: 1, Worker: 0},
+		{Kind: prodline.WorkerBlocked, Stage: 1, Worker: 1},
+		{Kind: prodline.CakeDone, Stage: 1, Worker: 1},
+		{Kind: prodline.WorkerDown, Stage: 2},
+		{Kind: prodline.CakeDone, Stage: 3},
+		{Kind: prodline.CakeDiscarded, Stage: 3},
+		{At: 2 * time.Second, Kind: prodline.BufferSampled, Stage: 0, Len: 2},
+	} {
+		d.Observe(e)
+	}
+
+	want := []string{
+		"run 1 running, 2s: 1 of 30 cakes packed, 1 discarded, 0.50 cakes/s",
+		"",
+		"baking      [W]     busy 1  blocked 0  idle 0  down 0      1 done  0.50 cakes/s",
+		"  baked     [##  ]  2/4",
+		"icing       [WB]    busy 1  blocked 1  idle 0  down 0      1 done  0.50 cakes/s",
+		"  iced      unbuffered",
+		"inscribing  [D]     busy 0  blocked 0  idle 0  down 1      0 done  0.00 cakes/s",
+		"  inscribed unbuffered",
+		"packing     [.]     busy 0  blocked 0  idle 1  down 0      1 done  0.50 cakes/s",
+		"  1 defective cakes discarded",
+		"  packed    unbuffered",
+		"",
+		"W working  B blocked  . idle  D down",
+		"",
+	}
+	got := strings.Split(lastFrame(t, d), "\n")
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestDashboard_ShowsFinishedRun(t *testing.T) {
+	t.Parallel()
+
+	b := bakery(2)
+	d := &prodline.Dashboard{Bakery: b}
+	b.Events = d.Observe
+	work(t, b, 1)
+	frame := lastFrame(t, d)
+	if !strings.HasPrefix(frame, "run 1 finished") || !strings.Contains(frame, "10 of 10 cakes packed") {
+		t.Errorf("want the finished run with all cakes packed, got\n%s", frame)
+	}
+}
+
+func TestDashboard_ShowsWorkersTheRunStartsWith(t *testing.T) {
+	t.Parallel()
+
+	for name, d := range map[string]*prodline.Dashboard{
+		"zero":       {},
+		"mismatched": {Bakery: bakery(1)},
+	} {
+		b := bakery(3)
+		b.Events = d.Observe
+		simulate(t, b, 1, 42)
+		frame := lastFrame(t, d)
+		if !strings.Contains(frame, "icing       [...]") {
+			t.Errorf("%s: want three icers shown, got\n%s", name, frame)
+		}
+	}
+}
+
+func TestDashboard_IgnoresEventsOfUnknownWorkers(t *testing.T) {
+	t.Parallel()
+
+	d := &prodline.Dashboard{}
+	for _, e := range []prodline.Event{
+		{Kind: prodline.WorkerWorking, Stage: 1, Worker: 2},
+		{Kind: prodline.CakeDiscarded, Stage: 0, Worker: 1},
+		{Kind: prodline.CakeDone, Stage: 4},
+		{Kind: prodline.BufferSampled, Stage: -1},
+	} {
+		d.Observe(e)
+	}
+	if frame := lastFrame(t, d); !strings.Contains(frame, "0 of 0 cakes packed") {
+		t.Errorf("want an empty line, got\n%s", frame)
+	}
+}
+
+func TestBakery_SimulateEmitsEventsInOrder(t *testing.T) {
+	t.Parallel()
+
+	b := slowBakery()
+	b.Cakes = 50
+	b.IceFaults = prodline.Faults{DefectRate: 0.1}
+	var mu sync.Mutex
+	var events []prodline.Event
+	b.Events = func(e prodline.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}
+	r := simulate(t, b, 1, 42)[0]
+
+	if len(events) < 2 || events[0].Kind != prodline.RunStarted || events[len(events)-1].Kind != prodline.RunFinished {
+		t.Fatalf("want events between run started and finished, got %v", events)
+	}
+	packed, discarded := 0, 0
+	for i, e := range events {
+		if i > 0 && e.At < events[i-1].At {
+			t.Fatalf("want events in order, got %v after %v", e, events[i-1])
+		}
+		switch {
+		case e.Kind == prodline.CakeDone && e.Stage == 3:
+			packed++
+		case e.Kind == prodline.CakeDiscarded:
+			discarded++
+		}
+	}
+	if packed != r.Cakes || discarded != r.Discarded {
+		t.Errorf("want %d cakes packed and %d discarded, got %d and %d", r.Cakes, r.Discarded, packed, discarded)
+	}
+}
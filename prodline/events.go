@@ -0,0 +1,70 @@
+package prodline
+
+import "time"
+
+// EventKind is what happened on the line.
+type EventKind int
+
+const (
+	// RunStarted and RunFinished bracket the events of a run.
+	RunStarted EventKind = iota
+	RunFinished
+
+	// A worker started working on a cake, got blocked
+	// passing it on to a full buffer, is waiting for
+	// a cake or had its machine break down.
+	WorkerWorking
+	WorkerBlocked
+	WorkerIdle
+	WorkerDown
+
+	// A worker finished with a cake or threw a defective one away.
+	CakeDone
+	CakeDiscarded
+
+	// The length of a buffer was sampled.
+	BufferSampled
+)
+
+var eventKinds = []string{
+	"run started", "run finished",
+	"working", "blocked", "idle", "down",
+	"cake done", "cake discarded",
+	"buffer sampled",
+}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKinds) {
+		return "unknown"
+	}
+	return eventKinds[k]
+}
+
+// Event is a change on the line, emitted to Bakery.Events
+// by the stages as it happens.
+type Event struct {
+	// At is the time since the start of the run.
+	At time.Duration
+
+	Kind EventKind
+
+	// Stage and Worker the event happened at, by index. For
+	// BufferSampled, Stage is the index of the buffer after
+	// the stage and Len is the buffer's length.
+	Stage, Worker int
+	Len           int
+
+	// Workers is the number of workers of each stage,
+	// set for RunStarted.
+	Workers []int
+}
+
+// emit sends the event, stamped with the time
+// of the run, to the bakery's Events.
+func (r *recorder) emit(e Event) {
+	if r.events == nil {
+		return
+	}
+	e.At = r.clock()
+	r.events(e)
+}
@@ -0,0 +1,127 @@
+package prodline
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Faults describes what goes wrong at a stage of the line.
+type Faults struct {
+	// DefectRate is the probability, from 0 to 1, that
+	// a cake comes out of the stage defective.
+	DefectRate float64
+
+	// Rework makes the worker redo a defective cake until it comes
+	// out right. Defective cakes are discarded if Rework is false.
+	Rework bool
+
+	// MTBF is the mean working time between breakdowns
+	// of a worker's machine, no breakdowns if zero.
+	MTBF time.Duration
+
+	// RepairTime a broken down machine takes to fix.
+	RepairTime time.Duration
+}
+
+// faults returns the faults of the stage.
+func (b *Bakery) faults(stage int) *Faults {
+	switch stage {
+	case baking:
+		return &b.BakeFaults
+	case icing:
+		return &b.IceFaults
+	case inscribing:
+		return &b.InscribeFaults
+	}
+	return &b.PackingFaults
+}
+
+// random is a source of random numbers, the math/rand
+// package's global one or a seeded *rand.Rand.
+type random interface {
+	Float64() float64
+	ExpFloat64() float64
+	NormFloat64() float64
+}
+
+// globalRandom is the math/rand package's source, safe for
+// concurrent use by the workers.
+type globalRandom struct{}
+
+func (globalRandom) Float64() float64     { return rand.Float64() }
+func (globalRandom) ExpFloat64() float64  { return rand.ExpFloat64() }
+func (globalRandom) NormFloat64() float64 { return rand.NormFloat64() }
+
+// defective draws whether the cake came out defective.
+func (f Faults) defective(rng random) bool {
+	return f.DefectRate > 0 && rng.Float64() < f.DefectRate
+}
+
+// machine tracks the working time left until
+// a worker's next breakdown.
+type machine struct {
+	faults Faults
+	uptime time.Duration
+	drawn  bool
+}
+
+// run accounts for the machine working for d and returns the
+// time it spent broken down in that period and the number of
+// breakdowns.
+func (m *machine) run(d time.Duration, rng random) (down time.Duration, breakdowns int) {
+	if m.faults.MTBF <= 0 {
+		return 0, 0
+	}
+	if !m.drawn {
+		m.uptime = m.nextBreakdown(rng)
+		m.drawn = true
+	}
+	for d >= m.uptime {
+		d -= m.uptime
+		down += m.faults.RepairTime
+		breakdowns++
+		m.uptime = m.nextBreakdown(rng)
+	}
+	m.uptime -= d
+	return down, breakdowns
+}
+
+func (m *machine) nextBreakdown(rng random) time.Duration {
+	return time.Duration(rng.ExpFloat64() * float64(m.faults.MTBF))
+}
+
+// make does the stage's work on the cake, in real time. A defective
+// cake is reworked if the stage reworks, otherwise make discards it
+// and reports false.
+func (b *Bakery) make(stage, id int, c cake, m *machine, rec *recorder) bool {
+	d, stddev := b.workTime(stage)
+	f := *b.faults(stage)
+	for {
+		rec.emit(Event{Kind: WorkerWorking, Stage: stage, Worker: id})
+		busy := work(d, stddev)
+		rec.busy(stage, id, busy)
+		if down, n := m.run(busy, globalRandom{}); n > 0 {
+			rec.emit(Event{Kind: WorkerDown, Stage: stage, Worker: id})
+			start := time.Now()
+			time.Sleep(down)
+			rec.down(stage, id, time.Since(start), n)
+		}
+		if !f.defective(globalRandom{}) {
+			rec.handled(stage, id)
+			return true
+		}
+		rec.defect(stage, f.Rework)
+		if f.Rework {
+			if b.Verbose {
+				fmt.Println("reworking", c)
+			}
+			continue
+		}
+		if b.Verbose {
+			fmt.Println("discarding", c)
+		}
+		rec.discard(stage, id, c)
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package prodline_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/prodline"
+)
+
+func TestBakery_SimulateDiscardsDefectiveCakes(t *testing.T) {
+	t.Parallel()
+
+	b := slowBakery()
+	b.IceFaults = prodline.Faults{DefectRate: 0.2}
+	r := simulate(t, b, 1, 42)[0]
+
+	if r.Discarded == 0 || r.Cakes+r.Discarded != b.Cakes {
+		t.Errorf("want %d cakes packed or discarded, got %d packed and %d discarded", b.Cakes, r.Cakes, r.Discarded)
+	}
+	icing := r.Stages[1]
+	if icing.Defects != r.Discarded || icing.Discarded != r.Discarded || icing.Reworked != 0 {
+		t.Errorf("want all %d discarded cakes defective in icing, got %d defects, %d discarded, %d reworked",
+			r.Discarded, icing.Defects, icing.Discarded, icing.Reworked)
+	}
+	if got := r.Stages[3].Workers[0].Cakes; got != r.Cakes {
+		t.Errorf("want the packer to handle %d cakes, got %d", r.Cakes, got)
+	}
+}
+
+func TestBakery_SimulateReworksDefectiveCakes(t *testing.T) {
+	t.Parallel()
+
+	b := slowBakery()
+	b.IceFaults = prodline.Faults{DefectRate: 0.2, Rework: true}
+	r := simulate(t, b, 1, 42)[0]
+	clean := b
+	clean.IceFaults = prodline.Faults{}
+	want := simulate(t, clean, 1, 42)[0]
+
+	if r.Cakes != b.Cakes || r.Discarded != 0 {
+		t.Errorf("want all %d cakes packed, got %d packed and %d discarded", b.Cakes, r.Cakes, r.Discarded)
+	}
+	icing := r.Stages[1]
+	if icing.Defects == 0 || icing.Reworked != icing.Defects {
+		t.Errorf("want every defect reworked, got %d defects and %d reworked", icing.Defects, icing.Reworked)
+	}
+	iced := 0
+	for _, w := range icing.Workers {
+		iced += w.Cakes
+	}
+	if iced != b.Cakes {
+		t.Errorf("want the icers to handle each cake once, got %d cakes", iced)
+	}
+	if r.Wall <= want.Wall {
+		t.Errorf("want rework to slow the line down from %v, got %v", want.Wall, r.Wall)
+	}
+}
+
+func TestBakery_SimulateRepairsBreakdowns(t *testing.T) {
+	t.Parallel()
+
+	b := slowBakery()
+	b.PackingFaults = prodline.Faults{MTBF: 30 * time.Minute, RepairTime: time.Hour}
+	r := simulate(t, b, 1, 42)[0]
+	clean := b
+	clean.PackingFaults = prodline.Faults{}
+	want := simulate(t, clean, 1, 42)[0]
+
+	packer := r.Stages[3].Workers[0]
+	if packer.Breakdowns == 0 {
+		t.Fatal("want the packer's machine to break down")
+	}
+	if want := time.Duration(packer.Breakdowns) * time.Hour; packer.Down != want {
+		t.Errorf("want %d breakdowns down for %v, got %v", packer.Breakdowns, want, packer.Down)
+	}
+	if got := packer.Busy + packer.Blocked + packer.Down + packer.Idle; got != r.Wall {
+		t.Errorf("want the packer's time to add up to %v, got %v", r.Wall, got)
+	}
+	if r.Cakes != b.Cakes || r.Wall <= want.Wall {
+		t.Errorf("want %d cakes packed slower than in %v, got %d in %v", b.Cakes, want.Wall, r.Cakes, r.Wall)
+	}
+}
+
+func TestBakery_WorkDiscardsDefectiveCakes(t *testing.T) {
+	t.Parallel()
+
+	b := bakery(1)
+	b.InscribeFaults = prodline.Faults{DefectRate: 1}
+	r := work(t, b, 1)[0]
+	if r.Cakes != 0 || r.Discarded != b.Cakes {
+		t.Errorf("want all %d cakes discarded, got %d packed and %d discarded", b.Cakes, r.Cakes, r.Discarded)
+	}
+	if got := r.Stages[2].Discarded; got != b.Cakes {
+		t.Errorf("want inscribing to discard %d cakes, got %d", b.Cakes, got)
+	}
+	if got := r.Stages[3].Workers[0].Cakes; got != 0 {
+		t.Errorf("want nothing to pack, got %d cakes", got)
+	}
+}
+
+func TestBakery_WorkReworksAndRepairs(t *testing.T) {
+	t.Parallel()
+
+	b := bakery(1)
+	b.IceFaults = prodline.Faults{DefectRate: 0.5, Rework: true}
+	b.PackingFaults = prodline.Faults{MTBF: 500 * time.Microsecond, RepairTime: time.Millisecond}
+	r := work(t, b, 1)[0]
+	if r.Cakes != b.Cakes || r.Discarded != 0 {
+		t.Errorf("want all %d cakes packed, got %d packed and %d discarded", b.Cakes, r.Cakes, r.Discarded)
+	}
+	if s := r.Stages[1]; s.Reworked != s.Defects {
+		t.Errorf("want every defect reworked, got %d defects and %d reworked", s.Defects, s.Reworked)
+	}
+	if w := r.Stages[3].Workers[0]; w.Breakdowns == 0 || w.Down < time.Duration(w.Breakdowns)*time.Millisecond {
+		t.Errorf("want the packer down a millisecond per breakdown, got %d breakdowns and %v down", w.Breakdowns, w.Down)
+	}
+}
+
+func TestBakery_RefusesToRunForever(t *testing.T) {
+	t.Parallel()
+
+	reworked := slowBakery()
+	reworked.IceFaults = prodline.Faults{DefectRate: 1, Rework: true}
+	broken := slowBakery()
+	broken.PackingFaults = prodline.Faults{MTBF: time.Nanosecond, RepairTime: time.Second}
+	for name, b := range map[string]prodline.Bakery{"rework": reworked, "mtbf": broken} {
+		if _, err := b.Simulate(1, 42); err == nil {
+			t.Errorf("%s: want Simulate to refuse the bakery", name)
+		}
+		if _, err := b.Work(1); err == nil {
+			t.Errorf("%s: want Work to refuse the bakery", name)
+		}
+	}
+}
@@ -29,112 +29,270 @@ type Bakery struct {
 	PackingTime   time.Duration
 	PackingStdDev time.Duration
 	PackerBuf     int
+
+	// Faults of the stages, none if zero.
+	BakeFaults     Faults
+	IceFaults      Faults
+	InscribeFaults Faults
+	PackingFaults  Faults
+
+	// SampleInterval between samples of the buffers' lengths. If zero,
+	// it is 100ms, or a tenth of the longest stage's work time in
+	// a simulation, whichever is longer.
+	SampleInterval time.Duration
+
+	// Events, if not nil, is called with every event of the
+	// runs. Work calls it from the workers' goroutines, so
+	// it must be safe for concurrent use and return quickly.
+	Events func(Event)
 }
 
 type cake int
 
+// Stages of the line and buffers between them, in the order cakes go through.
+var (
+	stageNames  = []string{"baking", "icing", "inscribing", "packing"}
+	bufferNames = []string{"baked", "iced", "inscribed", "packed"}
+)
+
+// Indexes of the stages.
+const (
+	baking = iota
+	icing
+	inscribing
+	packing
+)
+
 // baker represents a baking machine or a baker - person
 // responsible for baking cakes in the bakery.
 // baker bakes cakes and places them on a table. cakes at
 // this point are ready to ice - a job for the icer.
-func (b *Bakery) baker(out chan<- cake) {
+func (b *Bakery) baker(out chan<- cake, rec *recorder) {
+	m := &machine{faults: b.BakeFaults}
 	for i := 0; i < b.Cakes; i++ {
 		c := cake(i)
 		if b.Verbose {
 			fmt.Println("baking", c)
 		}
-		work(b.BakeTime, b.BakeStdDev)
-		out <- c
+		rec.start(c)
+		if b.make(baking, 0, c, m, rec) {
+			rec.pass(baking, 0, out, c)
+		}
+	}
+	if b.Verbose {
+		fmt.Println("baker done, closing")
 	}
-	fmt.Println("baker done, closing")
 }
 
 // icer represents a person or machine for puting ising
 // on the top of the cake. Icer sits in the middle of
 // the 'production' cakes chain between the baker and the inscriber.
-func (b *Bakery) icer(in <-chan cake, out chan<- cake) {
+func (b *Bakery) icer(id int, in <-chan cake, out chan<- cake, rec *recorder) {
+	m := &machine{faults: b.IceFaults}
 	// range over the channel
 	for c := range in {
 		if b.Verbose {
 			fmt.Println("icing", c)
 		}
-		work(b.IceTime, b.IceStdDev)
-		out <- c
+		if b.make(icing, id, c, m, rec) {
+			rec.pass(icing, id, out, c)
+		}
+	}
+	if b.Verbose {
+		fmt.Println("icer done, closing")
 	}
-	fmt.Println("icer done, closing")
 }
 
 // inscriber represents an entity (machine or a person) that
 // takes cakes from the icer and decorate them (inscribe).
 // inscriber is a last stage of the cake production line.
-func (b *Bakery) inscriber(in <-chan cake, out chan<- cake) {
+func (b *Bakery) inscriber(id int, in <-chan cake, out chan<- cake, rec *recorder) {
+	m := &machine{faults: b.InscribeFaults}
 	for c := range in {
 		if b.Verbose {
 			fmt.Println("inscribing", c)
 		}
-		work(b.InscribeTime, b.InscribeStdDev)
-		out <- c
+		if b.make(inscribing, id, c, m, rec) {
+			rec.pass(inscribing, id, out, c)
+		}
+	}
+	if b.Verbose {
+		fmt.Println("inscriber done, closing!")
 	}
-	fmt.Println("inscriber done, closing!")
 }
 
-func (b *Bakery) packer(in <-chan cake, out chan<- cake) {
+func (b *Bakery) packer(id int, in <-chan cake, out chan<- cake, rec *recorder) {
+	m := &machine{faults: b.PackingFaults}
 	for c := range in {
 		if b.Verbose {
 			fmt.Println("packaging", c)
 		}
-		work(b.PackingTime, b.PackingStdDev)
+		if !b.make(packing, id, c, m, rec) {
+			continue
+		}
 		if b.Verbose {
 			fmt.Println("finished packaging", c)
 		}
-		fmt.Println("packed", c)
-		out <- c
+		rec.pass(packing, id, out, c)
+	}
+	if b.Verbose {
+		fmt.Println("packer done, closing!")
 	}
-	fmt.Println("packer done, closing!")
 }
 
-// Work runs the baking simulation 'runs' times.
-func (b *Bakery) Work(runs int) {
+// Work runs the baking simulation 'runs' times and returns
+// the reports of the runs, or the problems with the bakery's
+// configuration, see Validate.
+func (b *Bakery) Work(runs int) ([]Report, error) {
+	if runs < 0 {
+		return nil, fmt.Errorf("runs: want at least 0, got %d", runs)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0, runs)
 	for run := 0; run < runs; run++ {
-		baked := make(chan cake, b.BakeBuf)
-		iced := make(chan cake, b.IceBuf)
-		inscribed := make(chan cake, b.InscribeBuf)
-		packed := make(chan cake, b.PackerBuf)
+		reports = append(reports, b.run())
+	}
+	return reports, nil
+}
 
-		// start baking using one baker (machine or human)
-		go b.baker(baked)
+// run runs the baking simulation once.
+func (b *Bakery) run() Report {
+	baked := make(chan cake, b.BakeBuf)
+	iced := make(chan cake, b.IceBuf)
+	inscribed := make(chan cake, b.InscribeBuf)
+	packed := make(chan cake, b.PackerBuf)
 
-		// start icing cakes - using 1 or more icers (machines or humans)
-		for i := 0; i < b.NumIcers; i++ {
-			go b.icer(baked, iced) // from backed -> to iced output
-		}
+	start := time.Now()
+	counts := []int{1, b.NumIcers, b.NumInscribers, b.NumPackers}
+	rec := newRecorder(func() time.Duration { return time.Since(start) }, counts...)
+	rec.events = b.Events
+	rec.emit(Event{Kind: RunStarted, Workers: counts})
+
+	// sample how the buffers fill up until all cakes are packed
+	buffers := []chan cake{baked, iced, inscribed, packed}
+	samples := make(chan []BufferReport)
+	stop := make(chan struct{})
+	go b.sample(start, buffers, rec, stop, samples)
+
+	// start baking using one baker (machine or human)
+	startStage(1, baked, func(int) { b.baker(baked, rec) })
+
+	// start icing cakes - using 1 or more icers (machines or humans)
+	startStage(b.NumIcers, iced, func(id int) {
+		b.icer(id, baked, iced, rec) // from backed -> to iced output
+	})
+
+	// start inscribing cakes - using 1 or more inscribers (machines or humans)
+	startStage(b.NumInscribers, inscribed, func(id int) {
+		b.inscriber(id, iced, inscribed, rec)
+	})
 
-		// start inscribing cakes - using 1 or more inscribers (machines or humans)
-		for i := 0; i < b.NumInscribers; i++ {
-			go b.inscriber(iced, inscribed)
+	// start packaging cakes for storage - using n packagers
+	// Packaging is the last step in the production line.
+	startStage(b.NumPackers, packed, func(id int) {
+		b.packer(id, inscribed, packed, rec)
+	})
+
+	// drain the queue until the last packer finishes
+	// and every stage has shut down.
+	for c := range packed {
+		rec.finish(c)
+		if b.Verbose {
+			fmt.Println("packed", c)
 		}
+	}
+	wall := time.Since(start)
+	close(stop)
+	buffered := <-samples
+	rec.emit(Event{Kind: RunFinished})
+	return rec.report(stageNames, wall, buffered)
+}
+
+// startStage runs n workers of a stage and closes the stage's
+// output when the last of them finishes, so the next stage's
+// workers, ranging over it, finish too.
+func startStage(n int, out chan<- cake, worker func(id int)) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(id int) {
+			defer wg.Done()
+			worker(id)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
 
-		// start packaging cakes for storage - using n packagers
-		// Packaging is the last step in the production line.
-		for i := 0; i < b.NumPackers; i++ {
-			go b.packer(inscribed, packed)
+// sample records lengths of the buffers every sample interval
+// until stop is closed, and then sends the samples.
+func (b *Bakery) sample(start time.Time, buffers []chan cake, rec *recorder, stop <-chan struct{}, samples chan<- []BufferReport) {
+	interval := b.sampleInterval()
+	reports := make([]BufferReport, len(buffers))
+	for i, buf := range buffers {
+		reports[i] = BufferReport{Name: bufferNames[i], Capacity: cap(buf)}
+	}
+	record := func() {
+		at := time.Since(start)
+		for i, buf := range buffers {
+			n := len(buf)
+			reports[i].Samples = append(reports[i].Samples, QueueSample{At: at, Len: n})
+			rec.emit(Event{Kind: BufferSampled, Stage: i, Len: n})
 		}
+	}
 
-		// drain the queue - loop n times where n it the number of cakes in the batch.
-		for i := 0; i < b.Cakes; i++ {
-			<-packed
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	record()
+	for {
+		select {
+		case <-ticker.C:
+			record()
+		case <-stop:
+			record()
+			samples <- reports
+			return
 		}
 	}
 }
 
 // work simulates a work items like baking, icing, packaging etc.
-func work(d, stddev time.Duration) {
+// It returns the time the work took.
+func work(d, stddev time.Duration) time.Duration {
+	start := time.Now()
 	delay := d + time.Duration(rand.NormFloat64()*float64(stddev))
 	time.Sleep(delay)
+	return time.Since(start)
 }
 
-func RunBakery() {
-	b := Bakery{
+// send passes the cake to the next stage.
+// It returns the time spent waiting for room in the buffer.
+func send(out chan<- cake, c cake) time.Duration {
+	start := time.Now()
+	out <- c
+	return time.Since(start)
+}
+
+// pass passes the worker's cake to the next stage, recording
+// the time the worker is blocked if the buffer is full.
+func (r *recorder) pass(stage, worker int, out chan<- cake, c cake) {
+	select {
+	case out <- c:
+	default:
+		r.emit(Event{Kind: WorkerBlocked, Stage: stage, Worker: worker})
+		r.blocked(stage, worker, send(out, c))
+	}
+	r.emit(Event{Kind: WorkerIdle, Stage: stage, Worker: worker})
+}
+
+// DemoBakery returns a small bakery with a few cakes
+// taking seconds to make.
+func DemoBakery() Bakery {
+	return Bakery{
 		Verbose: true,
 
 		Cakes:      3,
@@ -157,7 +315,19 @@ func RunBakery() {
 		PackingStdDev: 100 * time.Millisecond,
 		PackerBuf:     3,
 	}
-	b.Work(1)
+}
+
+// RunBakery runs the demo bakery once and prints the report.
+func RunBakery() {
+	b := DemoBakery()
+	reports, err := b.Work(1)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, r := range reports {
+		fmt.Print(r)
+	}
 }
 
 // concurent fetch emulation
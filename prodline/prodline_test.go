@@ -0,0 +1,141 @@
+package prodline_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/qba73/gocp/prodline"
+)
+
+// bakery returns a quick bakery with icing as the bottleneck.
+func bakery(icers int) prodline.Bakery {
+	return prodline.Bakery{
+		Cakes: 10,
+
+		BakeTime: 2 * time.Millisecond,
+		BakeBuf:  2,
+
+		NumIcers: icers,
+		IceTime:  10 * time.Millisecond,
+		IceBuf:   2,
+
+		NumInscribers: 1,
+		InscribeTime:  2 * time.Millisecond,
+		InscribeBuf:   2,
+
+		NumPackers:  1,
+		PackingTime: time.Millisecond,
+		PackerBuf:   2,
+
+		SampleInterval: 5 * time.Millisecond,
+	}
+}
+
+func work(t *testing.T, b prodline.Bakery, runs int) []prodline.Report {
+	t.Helper()
+	reports, err := b.Work(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reports
+}
+
+func TestBakery_WorkReportsEveryRun(t *testing.T) {
+	t.Parallel()
+
+	b := bakery(2)
+	reports := work(t, b, 2)
+	if len(reports) != 2 {
+		t.Fatalf("want 2 reports, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.Cakes != 10 || r.Wall <= 0 {
+		t.Errorf("want 10 cakes in some time, got %d in %v", r.Cakes, r.Wall)
+	}
+	if want := float64(r.Cakes) / r.Wall.Seconds(); r.Throughput != want {
+		t.Errorf("want throughput %f, got %f", want, r.Throughput)
+	}
+
+	wantWorkers := []int{1, 2, 1, 1}
+	if len(r.Stages) != len(wantWorkers) {
+		t.Fatalf("want %d stages, got %d", len(wantWorkers), len(r.Stages))
+	}
+	for i, s := range r.Stages {
+		if len(s.Workers) != wantWorkers[i] {
+			t.Errorf("%s: want %d workers, got %d", s.Name, wantWorkers[i], len(s.Workers))
+		}
+		if s.Utilisation <= 0 || s.Utilisation > 1 {
+			t.Errorf("%s: want utilisation in (0, 1], got %f", s.Name, s.Utilisation)
+		}
+		cakes := 0
+		for _, w := range s.Workers {
+			cakes += w.Cakes
+			if w.Idle < 0 || w.Busy+w.Blocked+w.Idle < r.Wall {
+				t.Errorf("%s: want busy, blocked and idle adding up to %v, got %+v", s.Name, r.Wall, w)
+			}
+		}
+		if cakes != r.Cakes {
+			t.Errorf("%s: want %d cakes handled, got %d", s.Name, r.Cakes, cakes)
+		}
+	}
+
+	if len(r.Buffers) != 4 {
+		t.Fatalf("want 4 buffers, got %d", len(r.Buffers))
+	}
+	for _, buf := range r.Buffers {
+		if buf.Capacity != 2 || len(buf.Samples) < 2 {
+			t.Errorf("%s: want capacity 2 and samples, got %+v", buf.Name, buf)
+		}
+		if buf.MaxLen() > buf.Capacity {
+			t.Errorf("%s: want at most %d cakes queued, got %d", buf.Name, buf.Capacity, buf.MaxLen())
+		}
+	}
+	// the baker outpaces the icer and waits for room
+	if r.Buffers[0].MaxLen() == 0 || r.Stages[0].Workers[0].Blocked == 0 {
+		t.Errorf("want baked cakes piling up before icing, got %+v", r.Buffers[0])
+	}
+}
+
+func TestBakery_WorkRejectsNegativeRuns(t *testing.T) {
+	t.Parallel()
+
+	b := bakery(1)
+	if _, err := b.Work(-1); err == nil {
+		t.Error("want error for negative runs")
+	}
+}
+
+func TestBakery_SecondIcerRaisesThroughput(t *testing.T) {
+	t.Parallel()
+
+	one, two := bakery(1), bakery(2)
+	single, double := work(t, one, 1)[0], work(t, two, 1)[0]
+	if double.Throughput < 1.3*single.Throughput {
+		t.Errorf("want a second icer to help, got %.1f cakes/s with one and %.1f with two", single.Throughput, double.Throughput)
+	}
+}
+
+func TestBakery_WorkLeavesNoGoroutinesBehind(t *testing.T) {
+	// not parallel, other tests' goroutines would be counted
+
+	before := runtime.NumGoroutine()
+	b := bakery(3)
+	b.NumInscribers, b.NumPackers = 2, 2
+	for _, r := range work(t, b, 5) {
+		if r.Cakes != b.Cakes {
+			t.Fatalf("want %d cakes, got %d", b.Cakes, r.Cakes)
+		}
+	}
+
+	// finished goroutines may take a moment to exit
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		buf := make([]byte, 1<<16)
+		buf = buf[:runtime.Stack(buf, true)]
+		t.Errorf("want %d goroutines after 5 runs, got %d:\n%s", before, after, buf)
+	}
+}
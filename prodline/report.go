@@ -0,0 +1,272 @@
+package prodline
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report describes a single run of the production line.
+type Report struct {
+	// Cakes packed during the run.
+	Cakes int
+
+	// Discarded defective cakes.
+	Discarded int
+
+	// Wall is the time the run took.
+	Wall time.Duration
+
+	// Throughput in cakes per second.
+	Throughput float64
+
+	// Latency is the mean and MaxLatency the longest time
+	// from the start of baking a cake to packing it.
+	Latency    time.Duration
+	MaxLatency time.Duration
+
+	// Stages in the order cakes go through them.
+	Stages []StageReport
+
+	// Buffers between the stages, Buffers[i] is
+	// the output of Stages[i].
+	Buffers []BufferReport
+}
+
+// StageReport describes how a stage of the line worked.
+type StageReport struct {
+	Name    string
+	Workers []WorkerReport
+
+	// Utilisation is the share of the run the stage's
+	// workers spent working, from 0 to 1.
+	Utilisation float64
+
+	// Defects is the number of defective cakes the stage made,
+	// Reworked of them were made again and Discarded thrown away.
+	Defects   int
+	Reworked  int
+	Discarded int
+}
+
+// WorkerReport describes how a worker spent the run.
+type WorkerReport struct {
+	// Cakes the worker handled, including discarded ones.
+	Cakes int
+
+	// Busy is the time spent working on cakes.
+	Busy time.Duration
+
+	// Blocked is the time spent waiting for room
+	// in the next stage's buffer.
+	Blocked time.Duration
+
+	// Down is the time spent on repairs after Breakdowns
+	// of the worker's machine.
+	Down       time.Duration
+	Breakdowns int
+
+	// Idle is the time spent waiting for cakes to work on,
+	// the rest of the run: Wall - Busy - Blocked - Down.
+	Idle time.Duration
+}
+
+// BufferReport describes how a buffer between stages filled up.
+type BufferReport struct {
+	Name     string
+	Capacity int
+	Samples  []QueueSample
+}
+
+// QueueSample is a number of cakes waiting in a buffer
+// at a time since the start of the run.
+type QueueSample struct {
+	At  time.Duration
+	Len int
+}
+
+// MaxLen returns the longest queue sampled.
+func (b BufferReport) MaxLen() int {
+	max := 0
+	for _, s := range b.Samples {
+		if s.Len > max {
+			max = s.Len
+		}
+	}
+	return max
+}
+
+// MeanLen returns the average length of the queue sampled.
+func (b BufferReport) MeanLen() float64 {
+	if len(b.Samples) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, s := range b.Samples {
+		sum += s.Len
+	}
+	return float64(sum) / float64(len(b.Samples))
+}
+
+// String returns the report as a human readable table.
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d cakes in %v, %.3f cakes/s, latency mean %v, max %v\n", r.Cakes, r.Wall.Round(time.Millisecond),
+		r.Throughput, r.Latency.Round(time.Millisecond), r.MaxLatency.Round(time.Millisecond))
+	if r.Discarded > 0 {
+		fmt.Fprintf(&b, "%d defective cakes discarded\n", r.Discarded)
+	}
+	for i, s := range r.Stages {
+		fmt.Fprintf(&b, "%-10s %d workers, %5.1f%% utilised\n", s.Name, len(s.Workers), 100*s.Utilisation)
+		if s.Defects > 0 {
+			fmt.Fprintf(&b, "  %d defects, %d reworked, %d discarded\n", s.Defects, s.Reworked, s.Discarded)
+		}
+		for j, w := range s.Workers {
+			fmt.Fprintf(&b, "  worker %d: %d cakes, busy %v, blocked %v, idle %v", j+1, w.Cakes,
+				w.Busy.Round(time.Millisecond), w.Blocked.Round(time.Millisecond), w.Idle.Round(time.Millisecond))
+			if w.Breakdowns > 0 {
+				fmt.Fprintf(&b, ", down %v after %d breakdowns", w.Down.Round(time.Millisecond), w.Breakdowns)
+			}
+			b.WriteString("\n")
+		}
+		if i < len(r.Buffers) {
+			buf := r.Buffers[i]
+			fmt.Fprintf(&b, "  buffer %s: capacity %d, mean %.2f, max %d\n", buf.Name, buf.Capacity, buf.MeanLen(), buf.MaxLen())
+		}
+	}
+	return b.String()
+}
+
+// recorder collects the statistics of a run from the workers.
+type recorder struct {
+	mu      sync.Mutex
+	clock   func() time.Duration // time since the start of the run
+	events  func(Event)          // the bakery's Events
+	workers [][]WorkerReport
+	defects []StageReport // only the defect counts
+	started map[cake]time.Duration
+	latency time.Duration // total
+	longest time.Duration
+	packed  int
+
+	discarded int
+}
+
+func newRecorder(clock func() time.Duration, workers ...int) *recorder {
+	r := &recorder{
+		clock:   clock,
+		workers: make([][]WorkerReport, len(workers)),
+		defects: make([]StageReport, len(workers)),
+		started: make(map[cake]time.Duration),
+	}
+	for i, n := range workers {
+		r.workers[i] = make([]WorkerReport, n)
+	}
+	return r
+}
+
+// start records the baking of the cake started.
+func (r *recorder) start(c cake) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started[c] = r.clock()
+}
+
+// finish records the cake packed.
+func (r *recorder) finish(c cake) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latency := r.clock() - r.started[c]
+	delete(r.started, c)
+	r.latency += latency
+	if latency > r.longest {
+		r.longest = latency
+	}
+	r.packed++
+}
+
+// discard records the worker threw the defective cake away.
+func (r *recorder) discard(stage, worker int, c cake) {
+	r.mu.Lock()
+	r.workers[stage][worker].Cakes++
+	delete(r.started, c)
+	r.discarded++
+	r.mu.Unlock()
+	r.emit(Event{Kind: CakeDiscarded, Stage: stage, Worker: worker})
+}
+
+// busy records the worker spent d working on a cake.
+func (r *recorder) busy(stage, worker int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers[stage][worker].Busy += d
+}
+
+// handled records the worker finished with a cake.
+func (r *recorder) handled(stage, worker int) {
+	r.mu.Lock()
+	r.workers[stage][worker].Cakes++
+	r.mu.Unlock()
+	r.emit(Event{Kind: CakeDone, Stage: stage, Worker: worker})
+}
+
+// down records the worker's machine broken down n times
+// and repaired in d.
+func (r *recorder) down(stage, worker int, d time.Duration, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers[stage][worker].Down += d
+	r.workers[stage][worker].Breakdowns += n
+}
+
+// defect records the stage made a defective cake.
+func (r *recorder) defect(stage int, reworked bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defects[stage].Defects++
+	if reworked {
+		r.defects[stage].Reworked++
+	} else {
+		r.defects[stage].Discarded++
+	}
+}
+
+// blocked records the worker spent d waiting to pass a cake on.
+func (r *recorder) blocked(stage, worker int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers[stage][worker].Blocked += d
+}
+
+// report returns the report of the run which took wall time.
+func (r *recorder) report(names []string, wall time.Duration, buffers []BufferReport) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := Report{Cakes: r.packed, Discarded: r.discarded, Wall: wall, Buffers: buffers}
+	if wall > 0 {
+		rep.Throughput = float64(r.packed) / wall.Seconds()
+	}
+	if r.packed > 0 {
+		rep.Latency = r.latency / time.Duration(r.packed)
+		rep.MaxLatency = r.longest
+	}
+	for i, workers := range r.workers {
+		s := r.defects[i]
+		s.Name, s.Workers = names[i], make([]WorkerReport, len(workers))
+		var busy time.Duration
+		for j, w := range workers {
+			w.Idle = wall - w.Busy - w.Blocked - w.Down
+			if w.Idle < 0 {
+				w.Idle = 0
+			}
+			s.Workers[j] = w
+			busy += w.Busy
+		}
+		if wall > 0 && len(workers) > 0 {
+			s.Utilisation = float64(busy) / float64(wall*time.Duration(len(workers)))
+		}
+		rep.Stages = append(rep.Stages, s)
+	}
+	return rep
+}
@@ -0,0 +1,316 @@
+package prodline
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Simulate runs the baking simulation 'runs' times in virtual time
+// and returns the reports of the runs, or the problems with the
+// bakery's configuration, see Validate.
+//
+// Instead of sleeping, a discrete-event engine jumps from one event
+// to the next, so a run finishes at once however long the work takes.
+// Work times are drawn from a random source seeded with seed, so the
+// same bakery and seed always produce the same reports. Buffers behave
+// like the channels Work uses: a worker passing a cake on to a full
+// buffer is blocked until a worker of the next stage takes a cake out.
+// Defects and breakdowns are drawn from the same source.
+//
+// Events are emitted in virtual time, in order. A worker whose
+// machine breaks down is shown working until it is repaired.
+func (b *Bakery) Simulate(runs int, seed int64) ([]Report, error) {
+	if runs < 0 {
+		return nil, fmt.Errorf("runs: want at least 0, got %d", runs)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return b.simulateRuns(runs, seed), nil
+}
+
+// simulateRuns runs the simulation of the valid bakery.
+func (b *Bakery) simulateRuns(runs int, seed int64) []Report {
+	rng := rand.New(rand.NewSource(seed))
+	reports := make([]Report, 0, runs)
+	for run := 0; run < runs; run++ {
+		reports = append(reports, b.simulate(rng))
+	}
+	return reports
+}
+
+// simulate runs the discrete-event simulation once.
+func (b *Bakery) simulate(rng random) Report {
+	s := &sim{b: b, rng: rng}
+	counts := []int{1, b.NumIcers, b.NumInscribers, b.NumPackers}
+	capacities := []int{b.BakeBuf, b.IceBuf, b.InscribeBuf, b.PackerBuf}
+	s.rec = newRecorder(func() time.Duration { return s.now }, counts...)
+	s.rec.events = b.Events
+	s.rec.emit(Event{Kind: RunStarted, Workers: counts})
+	for stage, n := range counts {
+		buf := &simBuffer{report: BufferReport{Name: bufferNames[stage], Capacity: capacities[stage]}}
+		s.buffers = append(s.buffers, buf)
+		workers := make([]*simWorker, n)
+		for i := range workers {
+			workers[i] = &simWorker{stage: stage, id: i, machine: machine{faults: *b.faults(stage)}}
+		}
+		s.workers = append(s.workers, workers)
+	}
+
+	// workers of later stages wait for cakes before the baker starts
+	for stage := len(s.workers) - 1; stage >= 0; stage-- {
+		for _, w := range s.workers[stage] {
+			s.next(w)
+		}
+	}
+	s.schedule(&event{at: 0, sample: true})
+
+	for s.events.Len() > 0 && s.packed+s.discarded < b.Cakes && s.pending > 0 {
+		e := heap.Pop(&s.events).(*event)
+		s.now = e.at
+		if e.sample {
+			s.sample()
+			s.schedule(&event{at: s.now + b.simSampleInterval(), sample: true})
+			continue
+		}
+		s.pending--
+		s.finish(e.worker)
+	}
+	s.sample()
+	s.rec.emit(Event{Kind: RunFinished})
+
+	buffers := make([]BufferReport, len(s.buffers))
+	for i, buf := range s.buffers {
+		buffers[i] = buf.report
+	}
+	return s.rec.report(stageNames, s.now, buffers)
+}
+
+func (b *Bakery) sampleInterval() time.Duration {
+	if b.SampleInterval <= 0 {
+		return 100 * time.Millisecond
+	}
+	return b.SampleInterval
+}
+
+// simSampleInterval is the SampleInterval or, if zero, a tenth of
+// the longest stage's work time. Virtual time flies, a fixed 100ms
+// would take thousands of samples of a line working for hours.
+func (b *Bakery) simSampleInterval() time.Duration {
+	if b.SampleInterval > 0 {
+		return b.SampleInterval
+	}
+	var longest time.Duration
+	for stage := range stageNames {
+		if d, _ := b.workTime(stage); d > longest {
+			longest = d
+		}
+	}
+	if longest < time.Second {
+		return b.sampleInterval()
+	}
+	return longest / 10
+}
+
+// workTime returns the mean and standard deviation
+// of the time the stage takes to work on a cake.
+func (b *Bakery) workTime(stage int) (d, stddev time.Duration) {
+	switch stage {
+	case baking:
+		return b.BakeTime, b.BakeStdDev
+	case icing:
+		return b.IceTime, b.IceStdDev
+	case inscribing:
+		return b.InscribeTime, b.InscribeStdDev
+	}
+	return b.PackingTime, b.PackingStdDev
+}
+
+// sim is the state of a discrete-event simulation run.
+type sim struct {
+	b   *Bakery
+	rng random
+	rec *recorder
+
+	now     time.Duration
+	seq     int
+	events  eventQueue
+	pending int // work events, the run is stuck without them
+
+	workers [][]*simWorker
+	buffers []*simBuffer // buffers[i] is the output of stage i
+	baked   int
+	packed  int
+
+	discarded int
+}
+
+// simWorker is a worker of a stage in the simulation.
+type simWorker struct {
+	stage, id int
+	cake      cake
+	since     time.Duration // the worker got blocked at
+	machine   machine
+}
+
+// simBuffer is a buffer between stages, behaving like a buffered channel.
+type simBuffer struct {
+	queue     []cake
+	senders   []*simWorker // blocked waiting for room
+	receivers []*simWorker // idle waiting for cakes
+	report    BufferReport
+}
+
+// start makes the worker work on the cake.
+func (s *sim) start(w *simWorker, c cake) {
+	d, stddev := s.b.workTime(w.stage)
+	delay := d + time.Duration(s.rng.NormFloat64()*float64(stddev))
+	if delay < 0 {
+		delay = 0
+	}
+	w.cake = c
+	s.rec.emit(Event{Kind: WorkerWorking, Stage: w.stage, Worker: w.id})
+	s.rec.busy(w.stage, w.id, delay)
+	down, n := w.machine.run(delay, s.rng)
+	if n > 0 {
+		s.rec.down(w.stage, w.id, down, n)
+	}
+	s.pending++
+	s.schedule(&event{at: s.now + delay + down, worker: w})
+}
+
+// finish checks the worker's cake for defects, reworking or
+// discarding a defective one, and passes a good one on.
+func (s *sim) finish(w *simWorker) {
+	f := *s.b.faults(w.stage)
+	if !f.defective(s.rng) {
+		s.rec.handled(w.stage, w.id)
+		s.offer(w)
+		return
+	}
+	s.rec.defect(w.stage, f.Rework)
+	if f.Rework {
+		s.start(w, w.cake)
+		return
+	}
+	s.rec.discard(w.stage, w.id, w.cake)
+	s.discarded++
+	s.next(w)
+}
+
+// offer passes the worker's finished cake on to the next stage.
+func (s *sim) offer(w *simWorker) {
+	if w.stage == len(s.buffers)-1 {
+		// the packed cakes are collected at once
+		s.rec.finish(w.cake)
+		s.packed++
+		s.next(w)
+		return
+	}
+	out := s.buffers[w.stage]
+	switch {
+	case len(out.receivers) > 0:
+		r := out.receivers[0]
+		out.receivers = out.receivers[1:]
+		s.start(r, w.cake)
+		s.next(w)
+	case len(out.queue) < out.report.Capacity:
+		out.queue = append(out.queue, w.cake)
+		s.next(w)
+	default:
+		w.since = s.now
+		out.senders = append(out.senders, w)
+		s.rec.emit(Event{Kind: WorkerBlocked, Stage: w.stage, Worker: w.id})
+	}
+}
+
+// next makes the free worker take the next cake to work on.
+func (s *sim) next(w *simWorker) {
+	if w.stage == baking {
+		if s.baked < s.b.Cakes {
+			s.baked++
+			s.rec.start(cake(s.baked - 1))
+			s.start(w, cake(s.baked-1))
+			return
+		}
+		s.rec.emit(Event{Kind: WorkerIdle, Stage: w.stage, Worker: w.id})
+		return
+	}
+	in := s.buffers[w.stage-1]
+	switch {
+	case len(in.queue) > 0:
+		c := in.queue[0]
+		in.queue = in.queue[1:]
+		if len(in.senders) > 0 {
+			// room in the buffer unblocks the first sender
+			snd := in.senders[0]
+			in.senders = in.senders[1:]
+			in.queue = append(in.queue, snd.cake)
+			s.unblock(snd)
+		}
+		s.start(w, c)
+	case len(in.senders) > 0:
+		// handed over directly, the buffer has no room
+		snd := in.senders[0]
+		in.senders = in.senders[1:]
+		s.start(w, snd.cake)
+		s.unblock(snd)
+	default:
+		in.receivers = append(in.receivers, w)
+		s.rec.emit(Event{Kind: WorkerIdle, Stage: w.stage, Worker: w.id})
+	}
+}
+
+// unblock frees the worker whose cake was passed on.
+func (s *sim) unblock(w *simWorker) {
+	s.rec.blocked(w.stage, w.id, s.now-w.since)
+	s.next(w)
+}
+
+// sample records the lengths of the buffers.
+func (s *sim) sample() {
+	for i, buf := range s.buffers {
+		buf.report.Samples = append(buf.report.Samples, QueueSample{At: s.now, Len: len(buf.queue)})
+		s.rec.emit(Event{Kind: BufferSampled, Stage: i, Len: len(buf.queue)})
+	}
+}
+
+func (s *sim) schedule(e *event) {
+	e.seq = s.seq
+	s.seq++
+	heap.Push(&s.events, e)
+}
+
+// event is a worker finishing a cake or, if sample
+// is true, a time to sample the buffers.
+type event struct {
+	at     time.Duration
+	seq    int // orders events happening at the same time
+	worker *simWorker
+	sample bool
+}
+
+// eventQueue is a priority queue of events ordered by time.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
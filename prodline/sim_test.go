@@ -0,0 +1,175 @@
+package prodline_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/prodline"
+)
+
+// slowBakery returns a bakery taking minutes to make a cake.
+func slowBakery() prodline.Bakery {
+	return prodline.Bakery{
+		Cakes: 1000,
+
+		BakeTime:   2 * time.Minute,
+		BakeStdDev: 30 * time.Second,
+		BakeBuf:    2,
+
+		NumIcers:  2,
+		IceTime:   3 * time.Minute,
+		IceStdDev: 30 * time.Second,
+		IceBuf:    1,
+
+		NumInscribers:  2,
+		InscribeTime:   3 * time.Minute,
+		InscribeStdDev: time.Minute,
+		InscribeBuf:    2,
+
+		NumPackers:    1,
+		PackingTime:   20 * time.Second,
+		PackingStdDev: 10 * time.Second,
+
+		SampleInterval: time.Hour,
+	}
+}
+
+func simulate(t *testing.T, b prodline.Bakery, runs int, seed int64) []prodline.Report {
+	t.Helper()
+	reports, err := b.Simulate(runs, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reports
+}
+
+func TestBakery_SimulateReportsSameRunsForSameSeed(t *testing.T) {
+	t.Parallel()
+
+	b := slowBakery()
+	start := time.Now()
+	first := simulate(t, b, 3, 42)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want days of baking simulated at once, took %v", elapsed)
+	}
+	second := simulate(t, b, 3, 42)
+	if !cmp.Equal(first, second) {
+		t.Error(cmp.Diff(first, second))
+	}
+	if cmp.Equal(first, simulate(t, b, 3, 43)) {
+		t.Error("want different runs for a different seed")
+	}
+	for _, r := range first {
+		if r.Cakes != 1000 {
+			t.Errorf("want 1000 cakes, got %d", r.Cakes)
+		}
+	}
+}
+
+func TestBakery_SimulateRejectsNegativeRuns(t *testing.T) {
+	t.Parallel()
+
+	b := slowBakery()
+	if _, err := b.Simulate(-1, 42); err == nil {
+		t.Error("want error for negative runs")
+	}
+}
+
+func TestBakery_SimulateFollowsTheBottleneck(t *testing.T) {
+	t.Parallel()
+
+	// icing takes twice as long as baking, so the baker
+	// waits for the icer to take each cake
+	b := prodline.Bakery{
+		Cakes: 10,
+
+		BakeTime:      time.Minute,
+		NumIcers:      1,
+		IceTime:       2 * time.Minute,
+		NumInscribers: 1,
+		NumPackers:    1,
+
+		SampleInterval: time.Minute,
+	}
+	r := simulate(t, b, 1, 1)[0]
+
+	if want := 21 * time.Minute; r.Wall != want {
+		t.Errorf("want wall time %v, got %v", want, r.Wall)
+	}
+	if want := 10 / (21 * time.Minute).Seconds(); r.Throughput != want {
+		t.Errorf("want throughput %f, got %f", want, r.Throughput)
+	}
+	// the first cake waits for nothing, the others are baked
+	// a minute before the icer can take them
+	if want := 39 * time.Minute / 10; r.Latency != want {
+		t.Errorf("want mean latency %v, got %v", want, r.Latency)
+	}
+	if want := 4 * time.Minute; r.MaxLatency != want {
+		t.Errorf("want max latency %v, got %v", want, r.MaxLatency)
+	}
+	want := []prodline.WorkerReport{
+		{Cakes: 10, Busy: 10 * time.Minute, Blocked: 9 * time.Minute, Idle: 2 * time.Minute},
+		{Cakes: 10, Busy: 20 * time.Minute, Idle: time.Minute},
+		{Cakes: 10, Idle: 21 * time.Minute},
+		{Cakes: 10, Idle: 21 * time.Minute},
+	}
+	var got []prodline.WorkerReport
+	for _, s := range r.Stages {
+		got = append(got, s.Workers...)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if want, got := 20.0/21.0, r.Stages[1].Utilisation; want != got {
+		t.Errorf("want icing utilisation %f, got %f", want, got)
+	}
+	if got := len(r.Buffers[0].Samples); got < 22 {
+		t.Errorf("want a sample every minute, got %d samples", got)
+	}
+}
+
+func TestBakery_SimulateSamplesByTheLongestWorkTime(t *testing.T) {
+	t.Parallel()
+
+	b := prodline.Bakery{
+		Cakes: 10,
+
+		BakeTime:      time.Minute,
+		NumIcers:      1,
+		IceTime:       2 * time.Minute,
+		NumInscribers: 1,
+		NumPackers:    1,
+	}
+	r := simulate(t, b, 1, 1)[0]
+
+	// a sample every 12s of the 21 minutes and one at the end
+	if want, got := 107, len(r.Buffers[0].Samples); want != got {
+		t.Errorf("want %d samples, got %d", want, got)
+	}
+}
+
+func TestBakery_SimulateBuffersLikeChannels(t *testing.T) {
+	t.Parallel()
+
+	// a fast baker fills the buffer before the slow icer
+	b := prodline.Bakery{
+		Cakes: 10,
+
+		BakeTime:      time.Second,
+		BakeBuf:       3,
+		NumIcers:      1,
+		IceTime:       time.Minute,
+		NumInscribers: 1,
+		NumPackers:    1,
+
+		SampleInterval: 30 * time.Second,
+	}
+	r := simulate(t, b, 1, 1)[0]
+	if want, got := 3, r.Buffers[0].MaxLen(); want != got {
+		t.Errorf("want baked buffer full with %d cakes, got %d", want, got)
+	}
+	if r.Stages[0].Workers[0].Blocked == 0 {
+		t.Error("want the baker blocked on the full buffer")
+	}
+}
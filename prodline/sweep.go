@@ -0,0 +1,243 @@
+package prodline
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Range of values of a swept parameter, from Min to Max by Step.
+// A zero Range keeps the value of the base bakery.
+type Range struct {
+	Min, Max, Step int
+}
+
+// ParseRange parses a range written as "N", "MIN-MAX" or "MIN-MAX:STEP".
+func ParseRange(s string) (Range, error) {
+	bounds, step, hasStep := strings.Cut(s, ":")
+	from, to, isRange := strings.Cut(bounds, "-")
+	r := Range{Step: 1}
+	var err error
+	if r.Min, err = strconv.Atoi(from); err != nil || r.Min < 0 {
+		return Range{}, fmt.Errorf("invalid range %q", s)
+	}
+	r.Max = r.Min
+	if isRange {
+		if r.Max, err = strconv.Atoi(to); err != nil || r.Max < r.Min {
+			return Range{}, fmt.Errorf("invalid range %q", s)
+		}
+	}
+	if hasStep {
+		if r.Step, err = strconv.Atoi(step); err != nil || r.Step < 1 {
+			return Range{}, fmt.Errorf("invalid range step %q", s)
+		}
+	}
+	return r, nil
+}
+
+// values returns the values in the range or base for a zero Range.
+func (r Range) values(base int) []int {
+	if r == (Range{}) {
+		return []int{base}
+	}
+	step := r.Step
+	if step < 1 {
+		step = 1
+	}
+	var values []int
+	for v := r.Min; v <= r.Max; v += step {
+		values = append(values, v)
+	}
+	return values
+}
+
+// Sweep simulates the base bakery with every combination
+// of the swept parameters.
+type Sweep struct {
+	Base Bakery
+
+	Icers, Inscribers, Packers              Range
+	BakeBuf, IceBuf, InscribeBuf, PackerBuf Range
+
+	// Runs simulated per configuration, 1 if zero.
+	Runs int
+
+	// Seed of the simulations. Every configuration is simulated
+	// with the same seed, so they are compared on the same luck.
+	Seed int64
+
+	// Parallel is a number of configurations simulated
+	// at once, runtime.GOMAXPROCS(0) if zero.
+	Parallel int
+}
+
+// SweepResult is the outcome of simulating a configuration,
+// averaged over its runs.
+type SweepResult struct {
+	Bakery Bakery
+
+	// Workers of all stages, including the baker.
+	Workers int
+
+	// Throughput in cakes per second and PerWorker,
+	// the throughput divided by the number of workers.
+	Throughput float64
+	PerWorker  float64
+
+	// Latency is the mean time from the start
+	// of baking a cake to packing it.
+	Latency time.Duration
+
+	// Wall is the mean time of a run.
+	Wall time.Duration
+
+	// Stalled is true if a run stopped before all the
+	// cakes were packed or discarded.
+	Stalled bool
+}
+
+// configs returns the bakeries to simulate, or the
+// problems with the first one which isn't valid.
+func (s *Sweep) configs() ([]Bakery, error) {
+	var configs []Bakery
+	b := s.Base
+	for _, b.NumIcers = range s.Icers.values(s.Base.NumIcers) {
+		for _, b.NumInscribers = range s.Inscribers.values(s.Base.NumInscribers) {
+			for _, b.NumPackers = range s.Packers.values(s.Base.NumPackers) {
+				for _, b.BakeBuf = range s.BakeBuf.values(s.Base.BakeBuf) {
+					for _, b.IceBuf = range s.IceBuf.values(s.Base.IceBuf) {
+						for _, b.InscribeBuf = range s.InscribeBuf.values(s.Base.InscribeBuf) {
+							for _, b.PackerBuf = range s.PackerBuf.values(s.Base.PackerBuf) {
+								if err := b.Validate(); err != nil {
+									return nil, fmt.Errorf("icers %d, inscribers %d, packers %d, buffers %d/%d/%d/%d: %w",
+										b.NumIcers, b.NumInscribers, b.NumPackers, b.BakeBuf, b.IceBuf, b.InscribeBuf, b.PackerBuf, err)
+								}
+								configs = append(configs, b)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return configs, nil
+}
+
+// Run simulates all the configurations and returns
+// their results in the order of the ranges. It simulates
+// nothing if any of the configurations isn't valid.
+func (s *Sweep) Run() ([]SweepResult, error) {
+	configs, err := s.configs()
+	if err != nil {
+		return nil, err
+	}
+	runs, parallel := s.Runs, s.Parallel
+	if runs <= 0 {
+		runs = 1
+	}
+	if parallel <= 0 {
+		parallel = runtime.GOMAXPROCS(0)
+	}
+
+	results := make([]SweepResult, len(configs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = simulateConfig(configs[j], runs, s.Seed)
+			}
+		}()
+	}
+	for j := range configs {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	return results, nil
+}
+
+// simulateConfig simulates the bakery and averages its reports.
+func simulateConfig(b Bakery, runs int, seed int64) SweepResult {
+	b.Verbose, b.Events = false, nil
+	r := SweepResult{Bakery: b, Workers: 1 + b.NumIcers + b.NumInscribers + b.NumPackers}
+	// the configurations are validated before the sweep
+	for _, rep := range b.simulateRuns(runs, seed) {
+		r.Throughput += rep.Throughput
+		r.Latency += rep.Latency
+		r.Wall += rep.Wall
+		if rep.Cakes+rep.Discarded < b.Cakes {
+			r.Stalled = true
+		}
+	}
+	r.Throughput /= float64(runs)
+	r.Latency /= time.Duration(runs)
+	r.Wall /= time.Duration(runs)
+	r.PerWorker = r.Throughput / float64(r.Workers)
+	return r
+}
+
+// Best returns the result with the highest throughput per worker.
+// With a target latency it returns the result with the fewest workers,
+// and then the highest throughput, among those meeting the target.
+// Stalled results are never the best. It reports false if
+// no result meets the target.
+func Best(results []SweepResult, target time.Duration) (SweepResult, bool) {
+	var best SweepResult
+	found := false
+	for _, r := range results {
+		if r.Stalled || target > 0 && r.Latency > target {
+			continue
+		}
+		if !found || better(r, best, target) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+func better(r, than SweepResult, target time.Duration) bool {
+	if target > 0 {
+		if r.Workers != than.Workers {
+			return r.Workers < than.Workers
+		}
+		return r.Throughput > than.Throughput
+	}
+	if r.PerWorker != than.PerWorker {
+		return r.PerWorker > than.PerWorker
+	}
+	return r.Throughput > than.Throughput
+}
+
+// WriteCSV writes the results as CSV with a header row,
+// durations in seconds and stalled results marked.
+func WriteCSV(w io.Writer, results []SweepResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"icers", "inscribers", "packers",
+		"bake_buf", "ice_buf", "inscribe_buf", "packer_buf",
+		"workers", "throughput", "throughput_per_worker", "latency_s", "wall_s", "stalled",
+	})
+	for _, r := range results {
+		b := r.Bakery
+		cw.Write([]string{
+			strconv.Itoa(b.NumIcers), strconv.Itoa(b.NumInscribers), strconv.Itoa(b.NumPackers),
+			strconv.Itoa(b.BakeBuf), strconv.Itoa(b.IceBuf), strconv.Itoa(b.InscribeBuf), strconv.Itoa(b.PackerBuf),
+			strconv.Itoa(r.Workers),
+			strconv.FormatFloat(r.Throughput, 'f', 6, 64),
+			strconv.FormatFloat(r.PerWorker, 'f', 6, 64),
+			strconv.FormatFloat(r.Latency.Seconds(), 'f', 3, 64),
+			strconv.FormatFloat(r.Wall.Seconds(), 'f', 3, 64),
+			strconv.FormatBool(r.Stalled),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,188 @@
+package prodline_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/prodline"
+)
+
+func TestParseRange(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		in   string
+		want prodline.Range
+	}{
+		{in: "3", want: prodline.Range{Min: 3, Max: 3, Step: 1}},
+		{in: "0", want: prodline.Range{Min: 0, Max: 0, Step: 1}},
+		{in: "1-4", want: prodline.Range{Min: 1, Max: 4, Step: 1}},
+		{in: "0-10:5", want: prodline.Range{Min: 0, Max: 10, Step: 5}},
+	}
+	for _, tc := range tcs {
+		got, err := prodline.ParseRange(tc.in)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if !cmp.Equal(tc.want, got) {
+			t.Errorf("%q: %s", tc.in, cmp.Diff(tc.want, got))
+		}
+	}
+	for _, in := range []string{"", "x", "-1", "4-1", "1-4:0", "1-4:x"} {
+		if _, err := prodline.ParseRange(in); err == nil {
+			t.Errorf("%q: want error", in)
+		}
+	}
+}
+
+// sweep returns a sweep over icers and inscribers
+// of a bakery where icing is the bottleneck.
+func sweep() prodline.Sweep {
+	return prodline.Sweep{
+		Base: prodline.Bakery{
+			Cakes:         50,
+			BakeTime:      time.Minute,
+			NumIcers:      1,
+			IceTime:       3 * time.Minute,
+			NumInscribers: 1,
+			InscribeTime:  time.Minute,
+			NumPackers:    1,
+			PackerBuf:     1,
+		},
+		Icers:      prodline.Range{Min: 1, Max: 4, Step: 1},
+		Inscribers: prodline.Range{Min: 1, Max: 2, Step: 1},
+		Runs:       2,
+		Seed:       1,
+	}
+}
+
+// run runs the sweep and fails the test on error.
+func run(t *testing.T, s prodline.Sweep) []prodline.SweepResult {
+	t.Helper()
+	results, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestSweep_SimulatesEveryCombination(t *testing.T) {
+	t.Parallel()
+
+	s := sweep()
+	results := run(t, s)
+	if len(results) != 8 {
+		t.Fatalf("want 4 x 2 configurations, got %d", len(results))
+	}
+	var got [][2]int
+	for _, r := range results {
+		got = append(got, [2]int{r.Bakery.NumIcers, r.Bakery.NumInscribers})
+		if want := 2 + r.Bakery.NumIcers + r.Bakery.NumInscribers; r.Workers != want {
+			t.Errorf("want %d workers, got %d", want, r.Workers)
+		}
+		if r.Bakery.PackerBuf != 1 {
+			t.Errorf("want packer buffer of the base bakery, got %d", r.Bakery.PackerBuf)
+		}
+	}
+	want := [][2]int{{1, 1}, {1, 2}, {2, 1}, {2, 2}, {3, 1}, {3, 2}, {4, 1}, {4, 2}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if !cmp.Equal(results, run(t, s)) {
+		t.Error("want the same results for the same seed")
+	}
+}
+
+func TestBest_MaximisesThroughputPerWorker(t *testing.T) {
+	t.Parallel()
+
+	s := sweep()
+	best, ok := prodline.Best(run(t, s), 0)
+	if !ok {
+		t.Fatal("want best configuration")
+	}
+	// three icers keep up with the baker, a fourth one idles
+	if best.Bakery.NumIcers != 3 || best.Bakery.NumInscribers != 1 {
+		t.Errorf("want 3 icers and 1 inscriber, got %d and %d", best.Bakery.NumIcers, best.Bakery.NumInscribers)
+	}
+}
+
+func TestBest_PicksFewestWorkersMeetingTargetLatency(t *testing.T) {
+	t.Parallel()
+
+	s := sweep()
+	results := run(t, s)
+	best, ok := prodline.Best(results, 10*time.Minute)
+	if !ok {
+		t.Fatal("want configuration meeting the target")
+	}
+	if best.Latency > 10*time.Minute {
+		t.Errorf("want latency within 10m, got %v", best.Latency)
+	}
+	for _, r := range results {
+		if r.Latency <= 10*time.Minute && r.Workers < best.Workers {
+			t.Errorf("want fewest workers, got %d while %d meet the target", best.Workers, r.Workers)
+		}
+	}
+	if _, ok := prodline.Best(results, time.Second); ok {
+		t.Error("want no configuration baking a cake in a second")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	t.Parallel()
+
+	s := sweep()
+	s.Icers, s.Inscribers = prodline.Range{Min: 3, Max: 3, Step: 1}, prodline.Range{}
+	var b strings.Builder
+	if err := prodline.WriteCSV(&b, run(t, s)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want header and a row, got:\n%s", b.String())
+	}
+	if want := "icers,inscribers,packers,bake_buf,ice_buf,inscribe_buf,packer_buf,workers,throughput,throughput_per_worker,latency_s,wall_s,stalled"; lines[0] != want {
+		t.Errorf("want header %q, got %q", want, lines[0])
+	}
+	if want := "3,1,1,0,0,0,1,6,"; !strings.HasPrefix(lines[1], want) {
+		t.Errorf("want row starting with %q, got %q", want, lines[1])
+	}
+	if !strings.HasSuffix(lines[1], ",false") {
+		t.Errorf("want row of a run packing all cakes, got %q", lines[1])
+	}
+}
+
+func TestSweep_RejectsConfigurationsWithoutWorkers(t *testing.T) {
+	t.Parallel()
+
+	s := sweep()
+	s.Icers = prodline.Range{Min: 0, Max: 2, Step: 1}
+	results, err := s.Run()
+	if err == nil || !strings.Contains(err.Error(), "icers: want at least 1 worker, got 0") {
+		t.Errorf("want error for 0 icers, got %v", err)
+	}
+	if results != nil {
+		t.Errorf("want nothing simulated, got %d results", len(results))
+	}
+}
+
+func TestBest_SkipsStalledResults(t *testing.T) {
+	t.Parallel()
+
+	results := []prodline.SweepResult{
+		{Workers: 3, Throughput: 0, Stalled: true},
+		{Workers: 4, Throughput: 0.01, PerWorker: 0.0025, Latency: 5 * time.Second},
+	}
+	for _, target := range []time.Duration{0, 10 * time.Second} {
+		best, ok := prodline.Best(results, target)
+		if !ok || best.Stalled {
+			t.Errorf("target %v: want the result packing all cakes, got %+v", target, best)
+		}
+	}
+	if _, ok := prodline.Best(results[:1], 10*time.Second); ok {
+		t.Error("want no best of stalled results")
+	}
+}
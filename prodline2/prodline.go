@@ -2,110 +2,212 @@ package prodline2
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 )
 
-type item int
+// StageFunc does the work of a stage on an item and returns
+// the item to pass on to the next stage.
+type StageFunc[T any] func(ctx context.Context, item T) (T, error)
 
-type Work func(d, stddev time.Duration)
+// ErrSkip is returned by a StageFunc to drop the item
+// without failing the line.
+var ErrSkip = errors.New("skip item")
 
-// ProductionLine represents an imaginary production line
-// that process N number of items per run.
-type ProductionLine struct {
-	Logger  log.Logger
-	Verbose bool
-	Stages  []workerFn
+// Stage of a production line.
+type Stage[T any] struct {
+	Name string
+
+	// Workers doing the stage's work at once, 1 if zero.
+	// Items leave a stage with more workers in the order
+	// they are finished, not the order they came in.
+	Workers int
+
+	// Buffer is the number of finished items the stage
+	// holds for the next stage before its workers block.
+	Buffer int
 
-	output <-chan item
-	ctx    context.Context
+	Func StageFunc[T]
 }
 
-type Stage struct{}
+// ProductionLine represents a production line passing
+// items of type T through its stages in turn.
+type ProductionLine[T any] struct {
+	// Logger logs the items leaving the stages if Verbose is
+	// true, log.Default() if nil.
+	Logger  *log.Logger
+	Verbose bool
+	Stages  []Stage[T]
 
-type workerFn func(context.Context, <-chan item, chan<- item)
+	output <-chan T
+	mu     sync.Mutex
+	err    error
+}
 
-func (pl *ProductionLine) AddStage(name string, worker workerFn) {
-	pl.Stages = append(pl.Stages, worker)
+// AddStage adds a stage with the workers and the buffer
+// at the end of the line.
+func (pl *ProductionLine[T]) AddStage(name string, workers, buffer int, fn StageFunc[T]) {
+	pl.Stages = append(pl.Stages, Stage[T]{Name: name, Workers: workers, Buffer: buffer, Func: fn})
 }
 
-// Run simulates running all stages of the production line.
-// It defines an initial and further stages of the line.
-func (pl *ProductionLine) Start() {
-	prev := make(chan item, 1)
+// Start starts the stages working on the items. The line
+// stops when the items channel is closed and the stages
+// finish with it, when ctx is cancelled or when a stage
+// fails. Items returns the items leaving the last stage.
+func (pl *ProductionLine[T]) Start(ctx context.Context, items <-chan T) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, s := range pl.Stages {
+		out := make(chan T, s.Buffer)
+		pl.startStage(ctx, cancel, &wg, s, items, out)
+		items = out
+	}
+	go func() {
+		// all the stages have finished by now
+		wg.Wait()
+		cancel()
+	}()
+	pl.output = items
+}
 
-	go func(ctx context.Context, ch chan<- item) {
-		i := 0
-		for {
-			select {
-			case <-ctx.Done():
-				fmt.Println("first stage cancelled!")
-				close(ch)
-				return
-			default:
-				ch <- item(i)
-				i++
+// startStage runs the stage's workers and closes its
+// output when the last of them finishes.
+func (pl *ProductionLine[T]) startStage(ctx context.Context, cancel context.CancelFunc, all *sync.WaitGroup, s Stage[T], in <-chan T, out chan<- T) {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	all.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer all.Done()
+			defer wg.Done()
+			if err := pl.work(ctx, s, in, out); err != nil {
+				pl.fail(err)
+				cancel()
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
+
+// work passes the items from in through the stage's
+// function to out until in is closed.
+func (pl *ProductionLine[T]) work(ctx context.Context, s Stage[T], in <-chan T, out chan<- T) error {
+	for {
+		var item T
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case it, ok := <-in:
+			if !ok {
+				return nil
 			}
+			item = it
 		}
-	}(pl.ctx, prev)
+		item, err := s.Func(ctx, item)
+		if errors.Is(err, ErrSkip) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.Name, err)
+		}
+		if pl.Verbose {
+			pl.logger().Printf("%s: %v", s.Name, item)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- item:
+		}
+	}
+}
 
-	for _, stage := range pl.Stages {
-		out := make(chan item, 1)
-		go stage(pl.ctx, prev, out)
-		prev = out
+// fail records the first error stopping the line.
+func (pl *ProductionLine[T]) fail(err error) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if pl.err == nil {
+		pl.err = err
 	}
-	pl.output = prev
 }
 
-func (pl *ProductionLine) Items() <-chan item {
-	return pl.output
+func (pl *ProductionLine[T]) logger() *log.Logger {
+	if pl.Logger == nil {
+		return log.Default()
+	}
+	return pl.Logger
 }
 
-// have a function that registers stages and run the full pipeline
+// Items returns the items leaving the last stage. The channel
+// is closed when the line stops.
+func (pl *ProductionLine[T]) Items() <-chan T {
+	return pl.output
+}
 
-func newDummyStage(t, stddev time.Duration) workerFn {
-	return func(ctx context.Context, in <-chan item, out chan<- item) {
-		for item := range in {
-			select {
-			case <-ctx.Done():
-				fmt.Println("worker cancelled!")
-				close(out)
-				return
-			default:
-			}
+// Err returns the error which stopped the line, the first
+// error of a stage, named after it, or ctx's error if it was
+// cancelled. It is nil if the line worked through all the
+// items. Call it once Items is closed.
+func (pl *ProductionLine[T]) Err() error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.err
+}
 
-			delay := t + time.Duration(rand.NormFloat64()*float64(stddev))
-			time.Sleep(delay)
-			out <- item
+// Delay returns a stage function which passes items on
+// unchanged after a delay of about d.
+func Delay[T any](d, stddev time.Duration) StageFunc[T] {
+	return func(ctx context.Context, item T) (T, error) {
+		delay := d + time.Duration(rand.NormFloat64()*float64(stddev))
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return item, ctx.Err()
+		case <-t.C:
+			return item, nil
 		}
 	}
 }
 
+// Run runs a demo line making numbered cakes for 10 seconds.
 func Run() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	pl := ProductionLine{
-		Logger:  *log.Default(),
-		Verbose: true,
-		ctx:     ctx,
-	}
-
-	pl.AddStage("baking", newDummyStage(time.Second, 200*time.Millisecond))
-	pl.AddStage("icing", newDummyStage(time.Second, 200*time.Millisecond))
-	pl.AddStage("inscribing", newDummyStage(time.Second, 200*time.Millisecond))
-	pl.AddStage("packaging", newDummyStage(time.Second, 200*time.Millisecond))
+	pl := ProductionLine[int]{Verbose: true}
+	pl.AddStage("baking", 1, 1, Delay[int](time.Second, 200*time.Millisecond))
+	pl.AddStage("icing", 2, 1, Delay[int](time.Second, 200*time.Millisecond))
+	pl.AddStage("inscribing", 2, 2, Delay[int](time.Second, 200*time.Millisecond))
+	pl.AddStage("packaging", 1, 3, Delay[int](time.Second, 200*time.Millisecond))
 
-	pl.Start()
+	cakes := make(chan int)
+	go func() {
+		defer close(cakes)
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case cakes <- i:
+			}
+		}
+	}()
+	pl.Start(ctx, cakes)
 
 	for item := range pl.Items() {
 		fmt.Println(item)
 	}
-}
-
-func work(d, stddev time.Duration) {
-	delay := d + time.Duration(rand.NormFloat64()*float64(stddev))
-	time.Sleep(delay)
+	if err := pl.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		fmt.Println(err)
+	}
 }
@@ -0,0 +1,122 @@
+package prodline2_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/qba73/gocp/prodline2"
+)
+
+// source sends the items on a closed channel.
+func source[T any](items ...T) <-chan T {
+	ch := make(chan T, len(items))
+	for _, it := range items {
+		ch <- it
+	}
+	close(ch)
+	return ch
+}
+
+func collect[T any](pl *prodline2.ProductionLine[T]) []T {
+	var items []T
+	for it := range pl.Items() {
+		items = append(items, it)
+	}
+	return items
+}
+
+func TestProductionLine_TransformsItemsThroughNamedStages(t *testing.T) {
+	t.Parallel()
+
+	var pl prodline2.ProductionLine[string]
+	pl.AddStage("trim", 2, 1, func(_ context.Context, s string) (string, error) {
+		return strings.TrimSpace(s), nil
+	})
+	pl.AddStage("filter", 1, 0, func(_ context.Context, s string) (string, error) {
+		if s == "" {
+			return s, prodline2.ErrSkip
+		}
+		return s, nil
+	})
+	pl.AddStage("upper", 3, 2, func(_ context.Context, s string) (string, error) {
+		return strings.ToUpper(s), nil
+	})
+
+	wantStages := []string{"trim/2/1", "filter/1/0", "upper/3/2"}
+	var gotStages []string
+	for _, s := range pl.Stages {
+		gotStages = append(gotStages, s.Name+"/"+strconv.Itoa(s.Workers)+"/"+strconv.Itoa(s.Buffer))
+	}
+	if !cmp.Equal(wantStages, gotStages) {
+		t.Error(cmp.Diff(wantStages, gotStages))
+	}
+
+	pl.Start(context.Background(), source(" extract ", "", "transform", "  ", "load "))
+	got := collect(&pl)
+	sort.Strings(got)
+	want := []string{"EXTRACT", "LOAD", "TRANSFORM"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if err := pl.Err(); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+}
+
+func TestProductionLine_StopsOnStageError(t *testing.T) {
+	t.Parallel()
+
+	errBad := errors.New("bad record")
+	var pl prodline2.ProductionLine[int]
+	pl.AddStage("parse", 2, 1, func(_ context.Context, n int) (int, error) {
+		if n == 3 {
+			return n, errBad
+		}
+		return n, nil
+	})
+	pl.AddStage("store", 1, 0, prodline2.Delay[int](time.Millisecond, 0))
+
+	// an endless source, only the failing stage stops the line
+	items := make(chan int)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case items <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	pl.Start(context.Background(), items)
+	collect(&pl)
+
+	err := pl.Err()
+	if !errors.Is(err, errBad) || !strings.HasPrefix(err.Error(), "parse: ") {
+		t.Errorf("want the parse stage's error, got %v", err)
+	}
+}
+
+func TestProductionLine_StopsWhenCancelled(t *testing.T) {
+	t.Parallel()
+
+	var pl prodline2.ProductionLine[int]
+	pl.AddStage("slow", 1, 0, prodline2.Delay[int](time.Hour, 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	pl.Start(ctx, source(1, 2, 3))
+
+	if got := collect(&pl); len(got) != 0 {
+		t.Errorf("want no items, got %v", got)
+	}
+	if err := pl.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want deadline exceeded, got %v", err)
+	}
+}